@@ -0,0 +1,186 @@
+package api
+
+import (
+	"Caronte/core"
+	"Caronte/orchestrator/discovery"
+	"Caronte/orchestrator/scaler"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ServiceView is a managed service with its live scaling state
+type ServiceView struct {
+	Service   core.CaronteService     `json:"service"`
+	Status    scaler.ServiceStatus    `json:"status"`
+	Decisions []scaler.DecisionRecord `json:"decisions,omitempty"`
+}
+
+// ReplicasRequest forces the replicas of a service for TTL seconds
+type ReplicasRequest struct {
+	Replicas int `json:"replicas"`
+	TTL      int `json:"ttl"`
+}
+
+type controlAPI struct {
+	discovery discovery.Discovery
+}
+
+// API serves the control API on the port until the returned server is shut down
+//
+//	GET    /services                   managed services with their live state
+//	GET    /services/{name}            service configuration, state and decision history
+//	POST   /services/{name}/pause      pause the autoscaling of the service
+//	POST   /services/{name}/resume     resume the autoscaling of the service
+//	PUT    /services/{name}/replicas   force the replicas for a ttl, body {"replicas": 3, "ttl": 600}
+//	DELETE /services/{name}/replicas   drop the forced replicas
+//	POST   /discovery                  run the service discovery now
+func API(port int, serviceDiscovery discovery.Discovery) *http.Server {
+
+	c := controlAPI{discovery: serviceDiscovery}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", c.services)
+	mux.HandleFunc("/services/", c.service)
+	mux.HandleFunc("/discovery", c.rediscover)
+
+	server := &http.Server{Addr: fmt.Sprint(":", port), Handler: mux}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			zap.S().Error(err)
+		}
+	}()
+
+	return server
+}
+
+func (c controlAPI) services(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, serviceViews())
+}
+
+func (c controlAPI) service(w http.ResponseWriter, r *http.Request) {
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/services"), "/"), "/")
+	name := parts[0]
+	if name == "" || len(parts) > 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	service, contains := discovery.GetActiveServices()[name]
+	if !contains {
+		http.Error(w, "service not found", http.StatusNotFound)
+		return
+	}
+
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		decisions, _ := scaler.Decisions(name)
+		writeJSON(w, http.StatusOK, ServiceView{Service: service, Status: scaler.Status(name), Decisions: decisions})
+
+	case action == "pause" && r.Method == http.MethodPost:
+		if controlled(w, scaler.Pause(r.Context(), service)) {
+			zap.S().Infof("Service %s autoscaling paused", name)
+			writeJSON(w, http.StatusOK, scaler.Status(name))
+		}
+
+	case action == "resume" && r.Method == http.MethodPost:
+		if controlled(w, scaler.Resume(r.Context(), service)) {
+			zap.S().Infof("Service %s autoscaling resumed", name)
+			writeJSON(w, http.StatusOK, scaler.Status(name))
+		}
+
+	case action == "replicas" && r.Method == http.MethodPut:
+		var request ReplicasRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.Replicas < 0 || request.TTL <= 0 {
+			http.Error(w, "replicas must not be negative and ttl must be positive", http.StatusBadRequest)
+			return
+		}
+		if controlled(w, scaler.ForceReplicas(r.Context(), service, request.Replicas, time.Duration(request.TTL)*time.Second)) {
+			zap.S().Infof("Service %s replicas forced to %d for %d seconds", name, request.Replicas, request.TTL)
+			writeJSON(w, http.StatusOK, scaler.Status(name))
+		}
+
+	case action == "replicas" && r.Method == http.MethodDelete:
+		if controlled(w, scaler.ClearReplicas(r.Context(), service)) {
+			zap.S().Infof("Service %s forced replicas cleared", name)
+			writeJSON(w, http.StatusOK, scaler.Status(name))
+		}
+
+	case action == "" || action == "pause" || action == "resume" || action == "replicas":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// controlled reports whether a control change was applied, answering the error otherwise. A
+// standby instance answers 503 so the request is sent to the leading one
+func controlled(w http.ResponseWriter, err error) bool {
+
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, scaler.ErrStandby):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		zap.S().Errorf("Control change failed: %s", err)
+		http.Error(w, fmt.Sprintf("state store: %s", err), http.StatusInternalServerError)
+	}
+	return false
+}
+
+func (c controlAPI) rediscover(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	zap.S().Info("Service discovery requested through the API")
+	c.discovery.CaronteServiceDiscovery(r.Context())
+	writeJSON(w, http.StatusOK, serviceViews())
+}
+
+// serviceViews returns the managed services sorted by name
+func serviceViews() []ServiceView {
+
+	services := discovery.GetActiveServices()
+	views := make([]ServiceView, 0, len(services))
+	for name, service := range services {
+		views = append(views, ServiceView{Service: service, Status: scaler.Status(name)})
+	}
+	sort.Slice(views, func(i, j int) bool {
+		return views[i].Service.Name < views[j].Service.Name
+	})
+	return views
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -1,34 +1,61 @@
 package core
 
 import (
+	"Caronte/forecast"
 	"Caronte/instances"
-	"Caronte/metricstores"
 	"strconv"
-	"time"
 
 	"github.com/docker/docker/api/types/swarm"
-	"go.uber.org/zap"
 )
 
 type CaronteService struct {
-	Id                   string
-	Name                 string
-	ServiceScheduler     int
-	Max                  int
-	Min                  int
-	MaxReplicasPerNode   int
-	ServiceCoolDownDelay int
-	Step                 int
-	ScaleUpThreshold     float64
-	ScaleDownThreshold   float64
-	Thread               int
-	MetricSpecs          metricstores.MetricSpecs
-	MetricProvider       metricstores.MetricProvider
-	InstanceSpecs        instances.ScaleSpecs
-	InstanceProvider     instances.InstanceManagerProvider
-	UpdatedAt            time.Time
+	Id                           string
+	Name                         string
+	ServiceScheduler             int
+	Max                          int
+	Min                          int
+	MaxReplicasPerNode           int
+	ServiceCoolDownDelay         int
+	ScaleDownStabilizationWindow int
+	IdleAfter                    int
+	ActivationReplicas           int
+	Activation                   ServiceMetric
+	Schedules                    []ScheduledCapacity
+	Step                         int
+	Policy                       string
+	MetricCombine                string
+	Metrics                      []ServiceMetric
+	Predictive                   PredictiveSpecs
+	PID                          PIDSpecs
+	DryRun                       bool
+	Errors                       []error
+	Thread                       int
+	InstanceSpecs                instances.ScaleSpecs
+	InstanceProvider             instances.InstanceManagerProvider
 }
 
+// PredictiveSpecs enables pre-scaling a service ahead of the seasonal forecast of one of its metrics
+type PredictiveSpecs struct {
+	Enable bool
+	Metric string
+	Specs  forecast.Specs
+}
+
+// PIDSpecs defines the gains and the integral windup limits of the pid policy
+type PIDSpecs struct {
+	Kp          float64
+	Ki          float64
+	Kd          float64
+	IntegralMin float64
+	IntegralMax float64
+}
+
+const (
+	ScalePolicyThreshold = "threshold"
+	ScalePolicyTarget    = "target"
+	ScalePolicyPID       = "pid"
+)
+
 var this CaronteService
 
 func NewCaronteService(id string, name string, annotations swarm.Annotations) CaronteService {
@@ -37,45 +64,65 @@ func NewCaronteService(id string, name string, annotations swarm.Annotations) Ca
 	max := labelStringToInt(annotations.Labels["caronte.scale.max"])
 	min := labelStringToInt(annotations.Labels["caronte.scale.min"])
 	step := labelStringToInt(annotations.Labels["caronte.scale.step"])
+	schedules := labelsToSchedules(annotations.Labels)
 	serviceCoolDownDelay := labelStringToInt(annotations.Labels["caronte.service.coolDownDelay"])
 	maxReplicasPerNode := labelStringToInt(annotations.Labels["caronte.scale.maxReplicasPerNode"])
+	scaleDownStabilizationWindow := labelStringToInt(annotations.Labels["caronte.scale.down.stabilizationWindow"])
+	idleAfter := labelStringToInt(annotations.Labels["caronte.scale.idleAfter"])
+	activationReplicas := labelStringToInt(annotations.Labels["caronte.scale.activationReplicas"])
+	if activationReplicas <= 0 {
+		activationReplicas = 1
+	}
 
-	scaleUpThreshold := labelStringToFloat(annotations.Labels["caronte.metric.scaleUpThreshold"])
-	scaleDownThreshold := labelStringToFloat(annotations.Labels["caronte.metric.scaleDownThreshold"])
-	store := annotations.Labels["caronte.metric.store"]
-	address := annotations.Labels["caronte.metric.prometheus.address"]
-	query := annotations.Labels["caronte.metric.query"]
-	period := labelStringToInt(annotations.Labels["caronte.metric.aws.period"])
-	queue := annotations.Labels["caronte.metric.sqs.queue"]
+	policy := annotations.Labels["caronte.scale.policy"]
+	if policy == "" {
+		policy = ScalePolicyThreshold
+	}
+	metricCombine := annotations.Labels["caronte.metric.combine"]
+	if metricCombine == "" {
+		metricCombine = MetricCombineMax
+	}
+	metrics := labelsToServiceMetrics(annotations.Labels, step)
+	activation := labelsToActivationMetric(annotations.Labels)
+	for i := range metrics {
+		metrics[i].MetricSpecs.ServiceID = id
+	}
+	activation.MetricSpecs.ServiceID = id
+	predictive := labelsToPredictive(annotations.Labels)
+	pid := PIDSpecs{
+		Kp:          labelStringToFloat(annotations.Labels["caronte.pid.kp"]),
+		Ki:          labelStringToFloat(annotations.Labels["caronte.pid.ki"]),
+		Kd:          labelStringToFloat(annotations.Labels["caronte.pid.kd"]),
+		IntegralMin: labelStringToFloat(annotations.Labels["caronte.pid.integralMin"]),
+		IntegralMax: labelStringToFloat(annotations.Labels["caronte.pid.integralMax"]),
+	}
+
+	dryRun := annotations.Labels["caronte.dryRun"] == "true"
 
 	provider := annotations.Labels["caronte.instance.provider"]
 	instanceCoolDownDelay := labelStringToInt(annotations.Labels["caronte.instance.coolDownDelay"])
 	filters := annotations.Labels["caronte.instance.aws.asg.filters"]
 
 	caronteService := CaronteService{
-		Id:                   id,
-		Name:                 name,
-		ServiceScheduler:     serviceScheduler,
-		Max:                  max,
-		Min:                  min,
-		MaxReplicasPerNode:   maxReplicasPerNode,
-		ServiceCoolDownDelay: serviceCoolDownDelay,
-		Step:                 step,
-		ScaleUpThreshold:     scaleUpThreshold,
-		ScaleDownThreshold:   scaleDownThreshold,
-		MetricSpecs: metricstores.MetricSpecs{
-			Store: store,
-			Query: query,
-			PrometheusStore: metricstores.MetricPrometheusStore{
-				Address: address,
-			},
-			AwsStore: metricstores.MetricCloudWatchStore{
-				Period: period,
-			},
-			SQSStore: metricstores.MetricSQSStore{
-				QueueName: queue,
-			},
-		},
+		Id:                           id,
+		Name:                         name,
+		ServiceScheduler:             serviceScheduler,
+		Max:                          max,
+		Min:                          min,
+		MaxReplicasPerNode:           maxReplicasPerNode,
+		ServiceCoolDownDelay:         serviceCoolDownDelay,
+		ScaleDownStabilizationWindow: scaleDownStabilizationWindow,
+		IdleAfter:                    idleAfter,
+		ActivationReplicas:           activationReplicas,
+		Activation:                   activation,
+		Schedules:                    schedules,
+		Step:                         step,
+		Policy:                       policy,
+		MetricCombine:                metricCombine,
+		Metrics:                      metrics,
+		Predictive:                   predictive,
+		PID:                          pid,
+		DryRun:                       dryRun,
 		InstanceSpecs: instances.ScaleSpecs{
 			Provider: provider,
 			CoolDown: instanceCoolDownDelay,
@@ -85,18 +132,56 @@ func NewCaronteService(id string, name string, annotations swarm.Annotations) Ca
 		},
 	}
 
-	caronteService.MetricProvider, _ = metricstores.MetricProviderStore{}.GetProvider(caronteService.MetricSpecs)
 	caronteService.InstanceProvider, _ = instances.InstanceProviderManager{}.GetProvider(caronteService.InstanceSpecs)
+	caronteService.Errors = caronteService.Validate(annotations.Labels)
 	this = caronteService
 
 	return caronteService
 }
 
+// labelsToPredictive reads the caronte.predictive.* labels. By default the default metric is
+// bucketed every 5 minutes, the season is one day, 7 days of history are kept and the service
+// is scaled for the value forecast 10 minutes ahead
+func labelsToPredictive(labels map[string]string) PredictiveSpecs {
+
+	predictive := PredictiveSpecs{
+		Enable: labels["caronte.predictive.enable"] == "true",
+		Metric: labels["caronte.predictive.metric"],
+		Specs: forecast.Specs{
+			Interval: labelStringToIntOrDefault(labels["caronte.predictive.interval"], 300),
+			Season:   labelStringToIntOrDefault(labels["caronte.predictive.season"], 86400),
+			History:  labelStringToIntOrDefault(labels["caronte.predictive.history"], 7),
+			Ahead:    labelStringToIntOrDefault(labels["caronte.predictive.ahead"], 600),
+			Alpha:    labelStringToFloatOrDefault(labels["caronte.predictive.alpha"], 0.5),
+			Beta:     labelStringToFloatOrDefault(labels["caronte.predictive.beta"], 0.05),
+			Gamma:    labelStringToFloatOrDefault(labels["caronte.predictive.gamma"], 0.3),
+		},
+	}
+	if predictive.Metric == "" {
+		predictive.Metric = DefaultMetric
+	}
+
+	return predictive
+}
+
+func labelStringToIntOrDefault(labelValue string, defaultValue int) int {
+	if labelValue == "" {
+		return defaultValue
+	}
+	return labelStringToInt(labelValue)
+}
+
+func labelStringToFloatOrDefault(labelValue string, defaultValue float64) float64 {
+	if labelValue == "" {
+		return defaultValue
+	}
+	return labelStringToFloat(labelValue)
+}
+
 func labelStringToFloat(labelValue string) float64 {
 	if labelValue != "" {
 		f, err := strconv.ParseFloat(labelValue, 64)
 		if err != nil {
-			zap.S().Warnf("Fail parsing label value to float %s", labelValue)
 			return 0
 		}
 		return f
@@ -108,7 +193,6 @@ func labelStringToInt(labelValue string) int {
 	if labelValue != "" {
 		i, err := strconv.Atoi(labelValue)
 		if err != nil {
-			zap.S().Warnf("Fail parsing label value to float %s", labelValue)
 			return 0
 		}
 		return i
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduledCapacity overrides the service Min and Max while the current time matches the cron
+// expression. The expression uses the standard five fields (minute hour day-of-month month
+// day-of-week), so "* 8-19 * * 1-5" is active on weekdays from 08:00 to 20:00
+type ScheduledCapacity struct {
+	Cron     string
+	Min      int
+	Max      int
+	Timezone string
+	cron     cronExpression
+	location *time.Location
+}
+
+type cronExpression struct {
+	minutes    uint64
+	hours      uint64
+	days       uint64
+	months     uint64
+	weekdays   uint64
+	anyDay     bool
+	anyWeekday bool
+}
+
+type cronField struct {
+	min int
+	max int
+}
+
+var cronFields = []cronField{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// Active tells whether the schedule window is active now
+func (s ScheduledCapacity) Active() bool {
+	return s.ActiveAt(time.Now())
+}
+
+// ActiveAt tells whether the schedule window is active at the given time
+func (s ScheduledCapacity) ActiveAt(now time.Time) bool {
+
+	if s.location != nil {
+		now = now.In(s.location)
+	}
+
+	c := s.cron
+	if c.minutes&(1<<uint(now.Minute())) == 0 ||
+		c.hours&(1<<uint(now.Hour())) == 0 ||
+		c.months&(1<<uint(now.Month())) == 0 {
+		return false
+	}
+
+	day := c.days&(1<<uint(now.Day())) != 0
+	weekday := c.weekdays&(1<<uint(now.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// ActiveSchedule returns the first schedule active at the given time
+func (c CaronteService) ActiveSchedule(now time.Time) (ScheduledCapacity, bool) {
+	for _, schedule := range c.Schedules {
+		if schedule.ActiveAt(now) {
+			return schedule, true
+		}
+	}
+	return ScheduledCapacity{}, false
+}
+
+// labelsToSchedules reads the indexed labels caronte.schedule.<n>.cron, .min, .max and .timezone
+func labelsToSchedules(labels map[string]string) []ScheduledCapacity {
+
+	var schedules []ScheduledCapacity
+
+	for i := 0; ; i++ {
+		prefix := fmt.Sprintf("caronte.schedule.%d.", i)
+		cron, ok := labels[prefix+"cron"]
+		if !ok {
+			break
+		}
+
+		schedule := ScheduledCapacity{
+			Cron:     cron,
+			Min:      labelStringToInt(labels[prefix+"min"]),
+			Max:      labelStringToInt(labels[prefix+"max"]),
+			Timezone: labels[prefix+"timezone"],
+		}
+
+		expression, err := parseCron(cron)
+		if err != nil {
+			continue
+		}
+		schedule.cron = expression
+
+		if schedule.Timezone != "" {
+			location, err := time.LoadLocation(schedule.Timezone)
+			if err != nil {
+				continue
+			}
+			schedule.location = location
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	return schedules
+}
+
+func parseCron(expression string) (cronExpression, error) {
+
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return cronExpression{}, errors.New("cron expression requires 5 fields")
+	}
+
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return cronExpression{}, err
+		}
+		sets[i] = set
+	}
+
+	//Sunday can be written as 0 or 7
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return cronExpression{
+		minutes:    sets[0],
+		hours:      sets[1],
+		days:       sets[2],
+		months:     sets[3],
+		weekdays:   sets[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+func parseCronField(field string, bounds cronField) (uint64, error) {
+
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			value, err := strconv.Atoi(part[i+1:])
+			if err != nil || value <= 0 {
+				return 0, fmt.Errorf("invalid cron step %s", part)
+			}
+			step = value
+			part = part[:i]
+		}
+
+		from, to := bounds.min, bounds.max
+		if part != "*" {
+			values := strings.SplitN(part, "-", 2)
+			value, err := strconv.Atoi(values[0])
+			if err != nil {
+				return 0, fmt.Errorf("invalid cron value %s", part)
+			}
+			from, to = value, value
+			if len(values) == 2 {
+				to, err = strconv.Atoi(values[1])
+				if err != nil {
+					return 0, fmt.Errorf("invalid cron value %s", part)
+				}
+			} else if step > 1 {
+				to = bounds.max
+			}
+		}
+
+		if from < bounds.min || to > bounds.max || from > to {
+			return 0, fmt.Errorf("cron value %s out of range", part)
+		}
+
+		for value := from; value <= to; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+
+	return set, nil
+}
@@ -0,0 +1,205 @@
+package core
+
+import (
+	"Caronte/metricstores"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultMetric is the name given to the metric defined with the caronte.metric.* labels
+const DefaultMetric = "default"
+
+// ActivationMetric is the name given to the metric defined with the caronte.activation.* labels
+const ActivationMetric = "activation"
+
+const (
+	MetricCombineMax  = "max"
+	MetricCombineVote = "vote"
+)
+
+const namedMetricsPrefix = "caronte.metrics."
+
+// ServiceMetric is a named metric definition with its own store, query and thresholds
+type ServiceMetric struct {
+	Name               string
+	Step               int
+	Steps              []StepAdjustment
+	ScaleUpThreshold   float64
+	ScaleDownThreshold float64
+	Target             float64
+	MetricSpecs        metricstores.MetricSpecs
+	MetricProvider     metricstores.MetricProvider
+}
+
+// StepAdjustment defines a scaling tier. Positive adjustments are applied when the metric is
+// greater than or equal to the threshold, negative ones when it is less than or equal to it
+type StepAdjustment struct {
+	Threshold  float64 `json:"threshold"`
+	Adjustment int     `json:"adjustment"`
+}
+
+// ScaleStep returns the scale direction and the replicas to move for a metric value.
+// When step tiers are defined the tier with the highest matching threshold is used to scale up
+// and the one with the lowest matching threshold to scale down, otherwise the thresholds and
+// the single step are used
+func (m ServiceMetric) ScaleStep(value float64) (int, int) {
+
+	if len(m.Steps) == 0 {
+		if value >= m.ScaleUpThreshold {
+			return 1, m.Step
+		} else if value <= m.ScaleDownThreshold {
+			return -1, m.Step
+		}
+		return 0, 0
+	}
+
+	var up, down *StepAdjustment
+	for i, tier := range m.Steps {
+		if tier.Adjustment > 0 && value >= tier.Threshold && (up == nil || tier.Threshold > up.Threshold) {
+			up = &m.Steps[i]
+		}
+		if tier.Adjustment < 0 && value <= tier.Threshold && (down == nil || tier.Threshold < down.Threshold) {
+			down = &m.Steps[i]
+		}
+	}
+
+	if up != nil {
+		return 1, up.Adjustment
+	} else if down != nil {
+		return -1, -down.Adjustment
+	}
+	return 0, 0
+}
+
+// labelsToServiceMetrics builds the default metric from the caronte.metric.* labels and one
+// metric for every name found in the caronte.metrics.<name>.* labels
+func labelsToServiceMetrics(labels map[string]string, step int) []ServiceMetric {
+
+	var metrics []ServiceMetric
+
+	if labels["caronte.metric.store"] != "" {
+		metrics = append(metrics, labelsToServiceMetric(DefaultMetric, labels, "caronte.metric.", "caronte.scale.", step))
+	}
+
+	names := make(map[string]bool)
+	for key := range labels {
+		if strings.HasPrefix(key, namedMetricsPrefix) {
+			name := strings.SplitN(strings.TrimPrefix(key, namedMetricsPrefix), ".", 2)[0]
+			if name != "" {
+				names[name] = true
+			}
+		}
+	}
+
+	var sortedNames []string
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	for _, name := range sortedNames {
+		prefix := namedMetricsPrefix + name + "."
+		metrics = append(metrics, labelsToServiceMetric(name, labels, prefix, prefix, step))
+	}
+
+	return metrics
+}
+
+// labelsToActivationMetric builds the metric that wakes up a service at zero replicas. The
+// service is woken up when the metric value is over caronte.activation.threshold
+func labelsToActivationMetric(labels map[string]string) ServiceMetric {
+
+	if labels["caronte.activation.store"] == "" {
+		return ServiceMetric{}
+	}
+
+	metric := labelsToServiceMetric(ActivationMetric, labels, "caronte.activation.", "caronte.activation.", 0)
+	metric.ScaleDownThreshold = labelStringToFloat(labels["caronte.activation.threshold"])
+	return metric
+}
+
+func labelsToServiceMetric(name string, labels map[string]string, metricPrefix string, scalePrefix string, step int) ServiceMetric {
+
+	if value, ok := labels[scalePrefix+"step"]; ok {
+		step = labelStringToInt(value)
+	}
+
+	metric := ServiceMetric{
+		Name:               name,
+		Step:               step,
+		Steps:              labelsToStepAdjustments(labels, scalePrefix+"steps"),
+		ScaleUpThreshold:   labelStringToFloat(labels[metricPrefix+"scaleUpThreshold"]),
+		ScaleDownThreshold: labelStringToFloat(labels[metricPrefix+"scaleDownThreshold"]),
+		Target:             labelStringToFloat(labels[metricPrefix+"target"]),
+		MetricSpecs: metricstores.MetricSpecs{
+			Store: labels[metricPrefix+"store"],
+			Query: labels[metricPrefix+"query"],
+			PrometheusStore: metricstores.MetricPrometheusStore{
+				Address:            labels[metricPrefix+"prometheus.address"],
+				Username:           labels[metricPrefix+"prometheus.basicAuth.username"],
+				PasswordSecret:     labels[metricPrefix+"prometheus.basicAuth.passwordSecret"],
+				BearerTokenSecret:  labels[metricPrefix+"prometheus.bearerTokenSecret"],
+				CASecret:           labels[metricPrefix+"prometheus.tls.caSecret"],
+				CertSecret:         labels[metricPrefix+"prometheus.tls.certSecret"],
+				KeySecret:          labels[metricPrefix+"prometheus.tls.keySecret"],
+				ServerName:         labels[metricPrefix+"prometheus.tls.serverName"],
+				InsecureSkipVerify: labels[metricPrefix+"prometheus.tls.insecureSkipVerify"] == "true",
+				Aggregate:          labels[metricPrefix+"prometheus.aggregate"],
+				Range:              labelStringToInt(labels[metricPrefix+"prometheus.range"]),
+				RangeStep:          labelStringToInt(labels[metricPrefix+"prometheus.rangeStep"]),
+				Reducer:            labels[metricPrefix+"prometheus.reducer"],
+			},
+			AwsStore: metricstores.MetricCloudWatchStore{
+				Period:   labelStringToInt(labels[metricPrefix+"aws.period"]),
+				Range:    labelStringToInt(labels[metricPrefix+"aws.range"]),
+				Reducer:  labels[metricPrefix+"aws.reducer"],
+				Queries:  labels[metricPrefix+"aws.queries"],
+				ReturnID: labels[metricPrefix+"aws.returnId"],
+			},
+			SQSStore: metricstores.MetricSQSStore{
+				QueueName:  labels[metricPrefix+"sqs.queue"],
+				QueueUrl:   labels[metricPrefix+"sqs.queueUrl"],
+				PerReplica: labels[metricPrefix+"sqs.perReplica"] == "true",
+			},
+			DockerStore: metricstores.MetricDockerStore{
+				Aggregate:  labels[metricPrefix+"docker.aggregate"],
+				RelativeTo: labels[metricPrefix+"docker.relativeTo"],
+			},
+		},
+	}
+
+	metric.MetricProvider, _ = metricstores.MetricProviderStore{}.GetProvider(metric.MetricSpecs)
+
+	return metric
+}
+
+// labelsToStepAdjustments reads the step tiers from <key> as a JSON list or from the
+// indexed labels <key>.<n>.threshold and <key>.<n>.adjustment
+func labelsToStepAdjustments(labels map[string]string, key string) []StepAdjustment {
+
+	var steps []StepAdjustment
+
+	if value := labels[key]; value != "" {
+		err := json.Unmarshal([]byte(value), &steps)
+		if err != nil {
+			return nil
+		}
+		return steps
+	}
+
+	for i := 0; ; i++ {
+		threshold, thresholdOk := labels[fmt.Sprintf("%s.%d.threshold", key, i)]
+		adjustment, adjustmentOk := labels[fmt.Sprintf("%s.%d.adjustment", key, i)]
+		if !thresholdOk && !adjustmentOk {
+			break
+		}
+		steps = append(steps, StepAdjustment{
+			Threshold:  labelStringToFloat(threshold),
+			Adjustment: labelStringToInt(adjustment),
+		})
+	}
+
+	return steps
+}
@@ -0,0 +1,306 @@
+package core
+
+import (
+	"Caronte/instances"
+	"Caronte/metricstores"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InvalidNumberError is a label whose value is not the number it should be
+type InvalidNumberError struct {
+	Label string
+	Value string
+	Kind  string
+}
+
+func (e InvalidNumberError) Error() string {
+	return fmt.Sprintf("label %s: %q is not a valid %s", e.Label, e.Value, e.Kind)
+}
+
+// UnsupportedValueError is a label whose value is not one of the values Caronte supports
+type UnsupportedValueError struct {
+	Label  string
+	Value  string
+	Reason string
+}
+
+func (e UnsupportedValueError) Error() string {
+	return fmt.Sprintf("label %s: %q %s", e.Label, e.Value, e.Reason)
+}
+
+// ConstraintError is a set of labels whose values do not make sense together
+type ConstraintError struct {
+	Labels []string
+	Reason string
+}
+
+func (e ConstraintError) Error() string {
+	return fmt.Sprintf("labels %s: %s", strings.Join(e.Labels, ", "), e.Reason)
+}
+
+// intLabelSuffixes and floatLabelSuffixes classify the numeric labels by the end of their key
+var intLabelSuffixes = []string{
+	".scale.time", ".max", ".min", ".step", ".maxReplicasPerNode", ".stabilizationWindow", ".idleAfter",
+	".activationReplicas", ".coolDownDelay", ".interval", ".season", ".history", ".ahead", ".period", ".adjustment",
+	".range", ".rangeStep",
+}
+var floatLabelSuffixes = []string{
+	".scaleUpThreshold", ".scaleDownThreshold", ".target", ".threshold", ".kp", ".ki", ".kd",
+	".integralMin", ".integralMax", ".alpha", ".beta", ".gamma",
+}
+var boolLabelSuffixes = []string{"caronte.dryRun", "caronte.predictive.enable", ".insecureSkipVerify", ".perReplica"}
+
+// Validate checks the labels a service was built from and returns every configuration error
+// found. A service with errors must not be scaled
+func (s CaronteService) Validate(labels map[string]string) []error {
+
+	var errs []error
+
+	var keys []string
+	for key := range labels {
+		if strings.HasPrefix(key, "caronte.") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		errs = append(errs, validateLabelValue(key, labels[key])...)
+	}
+
+	metrics := append([]ServiceMetric{s.Activation}, s.Metrics...)
+	for _, metric := range metrics {
+		if metric.Name == "" {
+			continue
+		}
+		errs = append(errs, validateMetric(s, metric)...)
+	}
+
+	if len(s.Metrics) == 0 && len(s.Schedules) == 0 {
+		errs = append(errs, ConstraintError{
+			Labels: []string{"caronte.metric.store", "caronte.metrics.<name>.store", "caronte.schedule.<n>.cron"},
+			Reason: "a metric or a schedule is required",
+		})
+	}
+	if s.Max <= 0 {
+		errs = append(errs, ConstraintError{Labels: []string{"caronte.scale.max"}, Reason: "max must be positive"})
+	}
+	if s.Min < 0 {
+		errs = append(errs, ConstraintError{Labels: []string{"caronte.scale.min"}, Reason: "min must not be negative"})
+	}
+	if s.Min > s.Max {
+		errs = append(errs, ConstraintError{Labels: []string{"caronte.scale.min", "caronte.scale.max"}, Reason: fmt.Sprintf("min %d is greater than max %d", s.Min, s.Max)})
+	}
+	if (s.Policy == ScalePolicyTarget || s.Policy == ScalePolicyPID) && s.Min == 0 && s.IdleAfter <= 0 {
+		errs = append(errs, ConstraintError{
+			Labels: []string{"caronte.scale.policy", "caronte.scale.min", "caronte.scale.idleAfter"},
+			Reason: "the " + s.Policy + " policy can not bring a service back from zero replicas, set a positive min or caronte.scale.idleAfter",
+		})
+	}
+	if s.Policy == ScalePolicyPID && s.PID.Ki <= 0 {
+		errs = append(errs, ConstraintError{
+			Labels: []string{"caronte.pid.ki"},
+			Reason: "the pid policy requires a positive ki, without the integral term the replicas settle away from the target",
+		})
+	}
+	if s.MetricCombine != MetricCombineMax && s.MetricCombine != MetricCombineVote {
+		errs = append(errs, UnsupportedValueError{Label: "caronte.metric.combine", Value: s.MetricCombine, Reason: "is not max or vote"})
+	}
+
+	if s.InstanceSpecs.Provider != "" {
+		if _, err := (instances.InstanceProviderManager{}).GetProvider(s.InstanceSpecs); err != nil {
+			errs = append(errs, UnsupportedValueError{Label: "caronte.instance.provider", Value: s.InstanceSpecs.Provider, Reason: "is not a supported instance provider"})
+		}
+		if s.MaxReplicasPerNode <= 0 {
+			errs = append(errs, ConstraintError{
+				Labels: []string{"caronte.instance.provider", "caronte.scale.maxReplicasPerNode"},
+				Reason: "maxReplicasPerNode must be positive with an instance provider",
+			})
+		}
+	}
+
+	for i := 0; ; i++ {
+		prefix := fmt.Sprintf("caronte.schedule.%d.", i)
+		cron, ok := labels[prefix+"cron"]
+		if !ok {
+			break
+		}
+		if _, err := parseCron(cron); err != nil {
+			errs = append(errs, UnsupportedValueError{Label: prefix + "cron", Value: cron, Reason: err.Error()})
+		}
+		if timezone := labels[prefix+"timezone"]; timezone != "" {
+			if _, err := time.LoadLocation(timezone); err != nil {
+				errs = append(errs, UnsupportedValueError{Label: prefix + "timezone", Value: timezone, Reason: "is not a known timezone"})
+			}
+		}
+	}
+	for i, schedule := range s.Schedules {
+		if schedule.Min > 0 && schedule.Max > 0 && schedule.Min > schedule.Max {
+			prefix := fmt.Sprintf("caronte.schedule.%d.", i)
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "min", prefix + "max"}, Reason: fmt.Sprintf("min %d is greater than max %d", schedule.Min, schedule.Max)})
+		}
+	}
+
+	if s.Predictive.Enable {
+		found := false
+		for _, metric := range s.Metrics {
+			found = found || metric.Name == s.Predictive.Metric
+		}
+		if !found {
+			errs = append(errs, UnsupportedValueError{Label: "caronte.predictive.metric", Value: s.Predictive.Metric, Reason: "is not a metric of the service"})
+		}
+	}
+
+	return errs
+}
+
+// validateLabelValue checks the value of a label has the type of its key
+func validateLabelValue(key string, value string) []error {
+
+	for _, suffix := range boolLabelSuffixes {
+		if strings.HasSuffix(key, suffix) && value != "true" && value != "false" {
+			return []error{UnsupportedValueError{Label: key, Value: value, Reason: "is not true or false"}}
+		}
+	}
+	if strings.HasSuffix(key, ".steps") && value != "" {
+		var steps []StepAdjustment
+		if err := json.Unmarshal([]byte(value), &steps); err != nil {
+			return []error{UnsupportedValueError{Label: key, Value: value, Reason: "is not a JSON list of step tiers"}}
+		}
+		return nil
+	}
+	if value == "" {
+		return nil
+	}
+	for _, suffix := range intLabelSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			if _, err := strconv.Atoi(value); err != nil {
+				return []error{InvalidNumberError{Label: key, Value: value, Kind: "integer"}}
+			}
+			return nil
+		}
+	}
+	for _, suffix := range floatLabelSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return []error{InvalidNumberError{Label: key, Value: value, Kind: "number"}}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// validateMetric checks the store and the thresholds of a metric for the service policy
+func validateMetric(s CaronteService, metric ServiceMetric) []error {
+
+	var errs []error
+
+	prefix := namedMetricsPrefix + metric.Name + "."
+	switch metric.Name {
+	case DefaultMetric:
+		prefix = "caronte.metric."
+	case ActivationMetric:
+		prefix = "caronte.activation."
+	}
+
+	if _, err := (metricstores.MetricProviderStore{}).GetProvider(metric.MetricSpecs); err != nil {
+		errs = append(errs, UnsupportedValueError{Label: prefix + "store", Value: metric.MetricSpecs.Store, Reason: "is not a supported metric store"})
+	}
+
+	if metric.MetricSpecs.Store == metricstores.Prometheus {
+		prometheus := metric.MetricSpecs.PrometheusStore
+		if prometheus.Address == "" {
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "prometheus.address"}, Reason: "the prometheus store requires an address"})
+		}
+		switch prometheus.Aggregate {
+		case "", metricstores.ReduceSum, metricstores.ReduceAvg, metricstores.ReduceMax, metricstores.ReduceMin, metricstores.ReduceCount:
+		default:
+			errs = append(errs, UnsupportedValueError{Label: prefix + "prometheus.aggregate", Value: prometheus.Aggregate, Reason: "is not sum, avg, max, min or count"})
+		}
+		switch prometheus.Reducer {
+		case "", metricstores.ReduceSum, metricstores.ReduceAvg, metricstores.ReduceMax, metricstores.ReduceMin, metricstores.ReduceLast:
+		default:
+			errs = append(errs, UnsupportedValueError{Label: prefix + "prometheus.reducer", Value: prometheus.Reducer, Reason: "is not avg, max, min, sum or last"})
+		}
+		if prometheus.Range < 0 || prometheus.RangeStep < 0 {
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "prometheus.range", prefix + "prometheus.rangeStep"}, Reason: "the range and its step must not be negative"})
+		}
+		if prometheus.Username != "" && prometheus.BearerTokenSecret != "" {
+			errs = append(errs, ConstraintError{
+				Labels: []string{prefix + "prometheus.basicAuth.username", prefix + "prometheus.bearerTokenSecret"},
+				Reason: "basic auth and bearer token can not be used together",
+			})
+		}
+	}
+
+	if metric.MetricSpecs.Store == metricstores.CloudWatch {
+		cloudWatch := metric.MetricSpecs.AwsStore
+		switch cloudWatch.Reducer {
+		case "", metricstores.ReduceLatest, metricstores.ReduceAvg, metricstores.ReduceMax, metricstores.ReduceMin, metricstores.ReduceSum:
+		default:
+			errs = append(errs, UnsupportedValueError{Label: prefix + "aws.reducer", Value: cloudWatch.Reducer, Reason: "is not latest, avg, max, min or sum"})
+		}
+		switch {
+		case cloudWatch.Period < 0 || cloudWatch.Range < 0:
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "aws.period", prefix + "aws.range"}, Reason: "the period and the range must not be negative"})
+		case cloudWatch.Queries == "" && cloudWatch.Period == 0:
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "aws.period"}, Reason: "a period is required by the query expression"})
+		case cloudWatch.Period == 0 && cloudWatch.Range == 0:
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "aws.period", prefix + "aws.range"}, Reason: "a period or a range is required to read datapoints"})
+		}
+		if cloudWatch.Queries == "" && metric.MetricSpecs.Query == "" {
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "query", prefix + "aws.queries"}, Reason: "the cloudwatch store requires a query"})
+		} else if _, _, err := cloudWatch.DataQueries(metric.MetricSpecs.Query); err != nil {
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "aws.queries", prefix + "aws.returnId"}, Reason: err.Error()})
+		}
+	}
+
+	if metric.MetricSpecs.Store == metricstores.Docker {
+		docker := metric.MetricSpecs.DockerStore
+		if metric.MetricSpecs.Query != metricstores.DockerCPU && metric.MetricSpecs.Query != metricstores.DockerMemory {
+			errs = append(errs, UnsupportedValueError{Label: prefix + "query", Value: metric.MetricSpecs.Query, Reason: "is not cpu or memory"})
+		}
+		if docker.Aggregate != "" && docker.Aggregate != metricstores.ReduceAvg && docker.Aggregate != metricstores.ReduceMax {
+			errs = append(errs, UnsupportedValueError{Label: prefix + "docker.aggregate", Value: docker.Aggregate, Reason: "is not avg or max"})
+		}
+		if docker.RelativeTo != "" && docker.RelativeTo != metricstores.DockerReservation && docker.RelativeTo != metricstores.DockerLimit {
+			errs = append(errs, UnsupportedValueError{Label: prefix + "docker.relativeTo", Value: docker.RelativeTo, Reason: "is not reservation or limit"})
+		}
+	}
+
+	if metric.MetricSpecs.Store == metricstores.SQS {
+		sqs := metric.MetricSpecs.SQSStore
+		if sqs.QueueName == "" && sqs.QueueUrl == "" {
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "sqs.queue", prefix + "sqs.queueUrl"}, Reason: "the sqs store requires a queue name or url"})
+		}
+		if strings.Trim(metric.MetricSpecs.Query, ", ") == "" {
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "query"}, Reason: "the sqs store requires the queue attributes to read"})
+		}
+	}
+
+	if metric.Name == ActivationMetric {
+		return errs
+	}
+
+	switch s.Policy {
+	case ScalePolicyThreshold:
+		if len(metric.Steps) == 0 && metric.ScaleUpThreshold <= metric.ScaleDownThreshold {
+			errs = append(errs, ConstraintError{
+				Labels: []string{prefix + "scaleUpThreshold", prefix + "scaleDownThreshold"},
+				Reason: fmt.Sprintf("scale up threshold %g must be greater than scale down threshold %g", metric.ScaleUpThreshold, metric.ScaleDownThreshold),
+			})
+		}
+	case ScalePolicyTarget, ScalePolicyPID:
+		if metric.Target <= 0 {
+			errs = append(errs, ConstraintError{Labels: []string{prefix + "target"}, Reason: "a positive target is required by the " + s.Policy + " policy"})
+		}
+	}
+
+	return errs
+}
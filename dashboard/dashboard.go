@@ -15,7 +15,8 @@ type PageData struct {
 	Services map[string]core.CaronteService
 }
 
-func Dashboard(port int) {
+// Dashboard serves the dashboard on the port until the returned server is shut down
+func Dashboard(port int) *http.Server {
 	templatesBox := packr.New("Templates", ".")
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -31,5 +32,13 @@ func Dashboard(port int) {
 		t.Execute(w, data)
 	})
 
-	http.ListenAndServe(fmt.Sprint(":", port), nil)
+	server := &http.Server{Addr: fmt.Sprint(":", port)}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			zap.S().Error(err)
+		}
+	}()
+
+	return server
 }
@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+)
+
+// StatsPort is the port of the Docker API of the other swarm nodes, used to read the stats of
+// their containers. With 0 only the containers of the node Caronte is connected to are read
+var StatsPort = 0
+
+// TaskStats is the resource usage of the container of a running task
+type TaskStats struct {
+	TaskID string
+	NodeID string
+	// CPU is the number of cores used
+	CPU float64
+	// Memory is the memory used in bytes, page cache excluded
+	Memory float64
+}
+
+// statsRequests bounds the container stats read at the same time for a service
+const statsRequests = 16
+
+// nodeClients keeps a Docker client per swarm node address
+var nodeClients = make(map[string]*client.Client)
+var nodeClientsLock sync.Mutex
+
+// ServiceTaskStats returns the usage of every running task of the service. The stats are read
+// concurrently, as Docker takes a second to sample each container. When the stats of a task
+// can not be read an error is returned, a subset of the tasks does not describe the service
+func (p SwarmClient) ServiceTaskStats(ctx context.Context, serviceID string) ([]TaskStats, error) {
+
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	info, err := p.DockerClient.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	taskFilters := filters.NewArgs()
+	taskFilters.Add("service", serviceID)
+	taskFilters.Add("desired-state", "running")
+	tasks, err := p.DockerClient.TaskList(ctx, types.TaskListOptions{
+		Filters: taskFilters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var running []swarm.Task
+	for _, task := range tasks {
+		if task.Status.State != swarm.TaskStateRunning || task.Status.ContainerStatus.ContainerID == "" {
+			continue
+		}
+		if task.NodeID != info.Swarm.NodeID && StatsPort <= 0 {
+			return nil, fmt.Errorf("task %s runs on node %s, set docker.stats.port to read its stats", task.ID, task.NodeID)
+		}
+		running = append(running, task)
+	}
+
+	stats := make([]TaskStats, len(running))
+	errs := make([]error, len(running))
+	requests := make(chan struct{}, statsRequests)
+	var wg sync.WaitGroup
+	for i, task := range running {
+		wg.Add(1)
+		go func(i int, task swarm.Task) {
+			defer wg.Done()
+			requests <- struct{}{}
+			defer func() { <-requests }()
+
+			dockerClient := p.DockerClient
+			if task.NodeID != info.Swarm.NodeID {
+				nodeClient, err := p.nodeClient(ctx, task.NodeID)
+				if err != nil {
+					errs[i] = fmt.Errorf("node %s stats not available: %s", task.NodeID, err)
+					return
+				}
+				dockerClient = nodeClient
+			}
+
+			taskStats, err := containerStats(ctx, dockerClient, task.Status.ContainerStatus.ContainerID)
+			if err != nil {
+				errs[i] = fmt.Errorf("task %s stats not available: %s", task.ID, err)
+				return
+			}
+			taskStats.TaskID = task.ID
+			taskStats.NodeID = task.NodeID
+			stats[i] = taskStats
+		}(i, task)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// nodeClient returns the client of the Docker API of a swarm node, with the TLS settings of
+// the client Caronte is connected with. The API of a node is only read over TLS, as it gives
+// control over the node
+func (p SwarmClient) nodeClient(ctx context.Context, nodeID string) (*client.Client, error) {
+
+	dockerCertPath := os.Getenv("DOCKER_CERT_PATH")
+	if dockerCertPath == "" {
+		return nil, fmt.Errorf("reading the Docker API of node %s requires TLS, set DOCKER_CERT_PATH", nodeID)
+	}
+
+	node, _, err := p.DockerClient.NodeInspectWithRaw(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node.Status.Addr == "" {
+		return nil, fmt.Errorf("node %s has no address", nodeID)
+	}
+	host := "tcp://" + net.JoinHostPort(node.Status.Addr, strconv.Itoa(StatsPort))
+
+	nodeClientsLock.Lock()
+	defer nodeClientsLock.Unlock()
+
+	if nodeClient, contains := nodeClients[host]; contains {
+		return nodeClient, nil
+	}
+
+	tlsClientConfig, err := tlsconfig.Client(tlsconfig.Options{
+		CAFile:             filepath.Join(dockerCertPath, "ca.pem"),
+		CertFile:           filepath.Join(dockerCertPath, "cert.pem"),
+		KeyFile:            filepath.Join(dockerCertPath, "key.pem"),
+		InsecureSkipVerify: os.Getenv("DOCKER_TLS_VERIFY") == "",
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpClient := &http.Client{
+		Transport:     &http.Transport{TLSClientConfig: tlsClientConfig},
+		CheckRedirect: client.CheckRedirect,
+	}
+
+	nodeClient, err := client.NewClient(host, p.DockerClient.ClientVersion(), httpClient, nil)
+	if err != nil {
+		return nil, err
+	}
+	nodeClients[host] = nodeClient
+
+	return nodeClient, nil
+}
+
+// containerStats reads one stats sample of a container. The CPU usage is the share of the
+// host CPU time used since the previous sample, in cores
+func containerStats(ctx context.Context, dockerClient *client.Client, containerID string) (TaskStats, error) {
+
+	response, err := dockerClient.ContainerStats(ctx, containerID, false)
+	if err != nil {
+		return TaskStats{}, err
+	}
+	defer response.Body.Close()
+
+	var stats types.StatsJSON
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return TaskStats{}, err
+	}
+
+	result := TaskStats{}
+
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		result.CPU = cpuDelta / systemDelta * cpus
+	}
+
+	memory := float64(stats.MemoryStats.Usage)
+	if cache, contains := stats.MemoryStats.Stats["total_inactive_file"]; contains {
+		memory -= float64(cache)
+	} else if cache, contains := stats.MemoryStats.Stats["inactive_file"]; contains {
+		memory -= float64(cache)
+	}
+	if memory > 0 {
+		result.Memory = memory
+	}
+
+	return result, nil
+}
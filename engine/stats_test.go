@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// statsDaemon serves the Docker API calls read by ServiceTaskStats. Every container stats
+// request takes sample, as the daemon waits for a second sample
+func statsDaemon(t *testing.T, tasks []swarm.Task, sample time.Duration, failing string) SwarmClient {
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/info"):
+			json.NewEncoder(w).Encode(types.Info{Swarm: swarm.Info{NodeID: "local"}})
+		case strings.HasSuffix(r.URL.Path, "/tasks"):
+			json.NewEncoder(w).Encode(tasks)
+		case strings.HasSuffix(r.URL.Path, "/stats"):
+			time.Sleep(sample)
+			if strings.Contains(r.URL.Path, "/"+failing+"/") {
+				http.Error(w, "container not found", http.StatusNotFound)
+				return
+			}
+			var stats types.StatsJSON
+			stats.MemoryStats.Usage = 300
+			stats.MemoryStats.Stats = map[string]uint64{"total_inactive_file": 100}
+			json.NewEncoder(w).Encode(stats)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	dockerClient, err := client.NewClient("tcp://"+strings.TrimPrefix(server.URL, "http://"), "1.30", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SwarmClient{DockerClient: dockerClient, Timeout: 5 * time.Second}
+}
+
+func runningTasks(count int, nodeID string) []swarm.Task {
+	var tasks []swarm.Task
+	for i := 0; i < count; i++ {
+		task := swarm.Task{ID: fmt.Sprint("task", i), NodeID: nodeID}
+		task.Status.State = swarm.TaskStateRunning
+		task.Status.ContainerStatus.ContainerID = fmt.Sprint("container", i)
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+func TestServiceTaskStatsReadsTasksConcurrently(t *testing.T) {
+
+	swarmClient := statsDaemon(t, runningTasks(10, "local"), 200*time.Millisecond, "")
+
+	started := time.Now()
+	stats, err := swarmClient.ServiceTaskStats(context.Background(), "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("reading 10 tasks took %s", elapsed)
+	}
+	if len(stats) != 10 {
+		t.Fatalf("got the stats of %d tasks, want 10", len(stats))
+	}
+	for _, task := range stats {
+		if task.Memory != 200 {
+			t.Errorf("task %s uses %g bytes, want 200", task.TaskID, task.Memory)
+		}
+	}
+}
+
+func TestServiceTaskStatsFailsOnMissingTasks(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		tasks   []swarm.Task
+		failing string
+		err     string
+	}{
+		{"task on another node", append(runningTasks(2, "local"), runningTasks(1, "remote")...), "", "set docker.stats.port"},
+		{"task without stats", runningTasks(3, "local"), "container1", "task task1 stats not available"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			swarmClient := statsDaemon(t, test.tasks, 0, test.failing)
+			stats, err := swarmClient.ServiceTaskStats(context.Background(), "api")
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got %d stats and error %v, want an error with %q", len(stats), err, test.err)
+			}
+		})
+	}
+}
+
+func TestNodeClientRequiresTLS(t *testing.T) {
+
+	defer func(port int) { StatsPort = port }(StatsPort)
+	StatsPort = 2376
+	defer os.Setenv("DOCKER_CERT_PATH", os.Getenv("DOCKER_CERT_PATH"))
+	os.Unsetenv("DOCKER_CERT_PATH")
+
+	swarmClient := statsDaemon(t, runningTasks(1, "remote"), 0, "")
+	_, err := swarmClient.ServiceTaskStats(context.Background(), "api")
+	if err == nil || !strings.Contains(err.Error(), "requires TLS") {
+		t.Errorf("got error %v, want the node API refused without TLS", err)
+	}
+}
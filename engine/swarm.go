@@ -2,28 +2,38 @@ package engine
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/swarm"
 	"github.com/docker/docker/client"
 	"go.uber.org/zap"
 )
 
+// Timeout bounds every Docker API call made through the SwarmClient, the events stream excluded
+var Timeout = 30 * time.Second
+
 type SwarmClient struct {
 	DockerClient *client.Client
+	Timeout      time.Duration
 }
 
 type SwarmEngine interface {
-	IsLeader(nodeID string) (bool, error)
-	ServiceCurrentReplicas(serviceID string) (int, error)
-	GetService(serviceID string) (swarm.Service, error)
-	GetServices(args filters.Args) ([]swarm.Service, error)
-	Scale(serviceID string, target int) (bool, error)
-	OnGoingTasks(serviceID string) (int, error)
-	PendingTasks(serviceID string) (int, error)
-	RunningTasks(serviceID string) (int, error)
-	TotalActiveTasks(serviceID string) (int, error)
+	NodeID(ctx context.Context) (string, error)
+	IsLeader(ctx context.Context, nodeID string) (bool, error)
+	ServiceCurrentReplicas(ctx context.Context, serviceID string) (int, error)
+	GetService(ctx context.Context, serviceID string) (swarm.Service, error)
+	GetServices(ctx context.Context, args filters.Args) ([]swarm.Service, error)
+	Scale(ctx context.Context, serviceID string, target int) (bool, error)
+	UpdateLabel(ctx context.Context, serviceID string, key string, value string) error
+	OnGoingTasks(ctx context.Context, serviceID string) (int, error)
+	PendingTasks(ctx context.Context, serviceID string) (int, error)
+	RunningTasks(ctx context.Context, serviceID string) (int, error)
+	TotalActiveTasks(ctx context.Context, serviceID string) (int, error)
+	Events(ctx context.Context, args filters.Args) (<-chan events.Message, <-chan error)
 }
 
 func NewSwarm() (SwarmClient, error) {
@@ -33,21 +43,49 @@ func NewSwarm() (SwarmClient, error) {
 		return SwarmClient{}, err
 	}
 
-	return SwarmClient{DockerClient: dockerClient}, nil
+	return SwarmClient{DockerClient: dockerClient, Timeout: Timeout}, nil
+}
+
+// withTimeout bounds a call with the client timeout
+func (p SwarmClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if p.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, p.Timeout)
+}
+
+// NodeID returns the swarm node ID of the Docker daemon Caronte is connected to
+func (p SwarmClient) NodeID(ctx context.Context) (string, error) {
+
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	info, err := p.DockerClient.Info(ctx)
+	if err != nil {
+		return "", err
+	}
+	return info.Swarm.NodeID, nil
 }
 
-func (p SwarmClient) IsLeader(nodeID string) (bool, error) {
+func (p SwarmClient) IsLeader(ctx context.Context, nodeID string) (bool, error) {
 
-	node, _, err := p.DockerClient.NodeInspectWithRaw(context.Background(), nodeID)
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	node, _, err := p.DockerClient.NodeInspectWithRaw(ctx, nodeID)
 	if err != nil {
 		return false, err
 	}
+	if node.ManagerStatus == nil {
+		return false, nil
+	}
 	return node.ManagerStatus.Leader, nil
 }
 
-func (p SwarmClient) ServiceCurrentReplicas(serviceID string) (int, error) {
+func (p SwarmClient) ServiceCurrentReplicas(ctx context.Context, serviceID string) (int, error) {
 
-	ctx := context.Background()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
 	service, _, err := p.DockerClient.ServiceInspectWithRaw(ctx, serviceID, types.ServiceInspectOptions{InsertDefaults: true})
 	if err != nil {
@@ -58,8 +96,9 @@ func (p SwarmClient) ServiceCurrentReplicas(serviceID string) (int, error) {
 
 }
 
-func (p SwarmClient) GetService(serviceID string) (swarm.Service, error) {
-	ctx := context.Background()
+func (p SwarmClient) GetService(ctx context.Context, serviceID string) (swarm.Service, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
 	service, _, err := p.DockerClient.ServiceInspectWithRaw(ctx, serviceID, types.ServiceInspectOptions{InsertDefaults: true})
 	if err != nil {
@@ -69,9 +108,12 @@ func (p SwarmClient) GetService(serviceID string) (swarm.Service, error) {
 	return service, nil
 }
 
-func (p SwarmClient) GetServices(args filters.Args) ([]swarm.Service, error) {
+func (p SwarmClient) GetServices(ctx context.Context, args filters.Args) ([]swarm.Service, error) {
+
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
-	services, err := p.DockerClient.ServiceList(context.Background(), types.ServiceListOptions{Filters: args})
+	services, err := p.DockerClient.ServiceList(ctx, types.ServiceListOptions{Filters: args})
 
 	if err != nil {
 		return nil, err
@@ -80,27 +122,24 @@ func (p SwarmClient) GetServices(args filters.Args) ([]swarm.Service, error) {
 	return services, nil
 }
 
-func (p SwarmClient) Scale(serviceID string, target int) (bool, error) {
+func (p SwarmClient) Scale(ctx context.Context, serviceID string, target int) (bool, error) {
 
-	ctx := context.Background()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
-	total, err := p.TotalActiveTasks(serviceID)
+	total, err := p.TotalActiveTasks(ctx, serviceID)
 	if err != nil {
 		zap.S().Error(err)
 	}
 
 	if total != target {
 
-		service, _, err := p.DockerClient.ServiceInspectWithRaw(ctx, serviceID, types.ServiceInspectOptions{})
-		if err != nil {
-			return false, err
-		}
-
-		zap.S().Infof("Scale service %s from %d to %d replicas", service.Spec.Name, total, target)
-		targetScale := uint64(target)
-		service.Spec.Mode.Replicated.Replicas = &targetScale
-
-		_, err = p.DockerClient.ServiceUpdate(ctx, service.ID, service.Version, service.Spec, types.ServiceUpdateOptions{})
+		err := p.updateService(ctx, serviceID, func(service *swarm.Service) bool {
+			zap.S().Infof("Scale service %s from %d to %d replicas", service.Spec.Name, total, target)
+			targetScale := uint64(target)
+			service.Spec.Mode.Replicated.Replicas = &targetScale
+			return true
+		})
 		if err != nil {
 			return false, err
 		}
@@ -111,8 +150,58 @@ func (p SwarmClient) Scale(serviceID string, target int) (bool, error) {
 	return false, nil
 
 }
-func (p SwarmClient) PendingTasks(serviceID string) (int, error) {
-	ctx := context.Background()
+
+// UpdateLabel sets a label of the service spec. The service tasks are not restarted as the
+// task template does not change
+func (p SwarmClient) UpdateLabel(ctx context.Context, serviceID string, key string, value string) error {
+
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
+
+	return p.updateService(ctx, serviceID, func(service *swarm.Service) bool {
+		if service.Spec.Labels[key] == value {
+			return false
+		}
+		if service.Spec.Labels == nil {
+			service.Spec.Labels = make(map[string]string)
+		}
+		service.Spec.Labels[key] = value
+		return true
+	})
+}
+
+// updateAttempts bounds the updates of a service retried after a version conflict
+const updateAttempts = 3
+
+// updateService applies the change to the latest spec of the service. The scaling and the
+// labels state store update the same service from different goroutines, or a user updates it
+// meanwhile, so an update based on an outdated version is retried with the new one. The change
+// returns false when the service needs no update
+func (p SwarmClient) updateService(ctx context.Context, serviceID string, change func(service *swarm.Service) bool) error {
+
+	var err error
+	for attempt := 0; attempt < updateAttempts; attempt++ {
+		var service swarm.Service
+		service, _, err = p.DockerClient.ServiceInspectWithRaw(ctx, serviceID, types.ServiceInspectOptions{})
+		if err != nil {
+			return err
+		}
+		if !change(&service) {
+			return nil
+		}
+
+		_, err = p.DockerClient.ServiceUpdate(ctx, service.ID, service.Version, service.Spec, types.ServiceUpdateOptions{})
+		if err == nil || !strings.Contains(err.Error(), "update out of sequence") {
+			return err
+		}
+		zap.S().Debugf("Service %s updated meanwhile, retrying", service.Spec.Name)
+	}
+	return err
+}
+
+func (p SwarmClient) PendingTasks(ctx context.Context, serviceID string) (int, error) {
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
 	taskFilters := filters.NewArgs()
 	taskFilters.Add("service", serviceID)
@@ -133,9 +222,10 @@ func (p SwarmClient) PendingTasks(serviceID string) (int, error) {
 	return pending, nil
 }
 
-func (p SwarmClient) TotalActiveTasks(serviceID string) (int, error) {
+func (p SwarmClient) TotalActiveTasks(ctx context.Context, serviceID string) (int, error) {
 
-	ctx := context.Background()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
 	taskFilters := filters.NewArgs()
 	taskFilters.Add("service", serviceID)
@@ -163,9 +253,10 @@ func (p SwarmClient) TotalActiveTasks(serviceID string) (int, error) {
 
 	return ongoing, nil
 }
-func (p SwarmClient) OnGoingTasks(serviceID string) (int, error) {
+func (p SwarmClient) OnGoingTasks(ctx context.Context, serviceID string) (int, error) {
 
-	ctx := context.Background()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
 	taskFilters := filters.NewArgs()
 	taskFilters.Add("service", serviceID)
@@ -193,9 +284,10 @@ func (p SwarmClient) OnGoingTasks(serviceID string) (int, error) {
 	return ongoing, nil
 }
 
-func (p SwarmClient) RunningTasks(serviceID string) (int, error) {
+func (p SwarmClient) RunningTasks(ctx context.Context, serviceID string) (int, error) {
 
-	ctx := context.Background()
+	ctx, cancel := p.withTimeout(ctx)
+	defer cancel()
 
 	taskFilters := filters.NewArgs()
 	taskFilters.Add("service", serviceID)
@@ -219,3 +311,8 @@ func (p SwarmClient) RunningTasks(serviceID string) (int, error) {
 
 	return running, nil
 }
+
+// Events streams the Docker events matching the filters until the context is cancelled
+func (p SwarmClient) Events(ctx context.Context, args filters.Args) (<-chan events.Message, <-chan error) {
+	return p.DockerClient.Events(ctx, types.EventsOptions{Filters: args})
+}
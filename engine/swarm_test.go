@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/client"
+)
+
+// versionedDaemon keeps a service and rejects the updates of an outdated version, as swarm does.
+// Inspecting the service takes a while so concurrent updates read the same version
+type versionedDaemon struct {
+	lock      sync.Mutex
+	service   swarm.Service
+	conflicts int
+}
+
+func (d *versionedDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/tasks"):
+		json.NewEncoder(w).Encode([]swarm.Task{})
+
+	case strings.HasSuffix(r.URL.Path, "/update"):
+		var spec swarm.ServiceSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		version, _ := strconv.ParseUint(r.URL.Query().Get("version"), 10, 64)
+
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if version != d.service.Version.Index {
+			d.conflicts++
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"message": "rpc error: code = Unknown desc = update out of sequence"})
+			return
+		}
+		d.service.Spec = spec
+		d.service.Version.Index++
+		json.NewEncoder(w).Encode(types.ServiceUpdateResponse{})
+
+	case strings.Contains(r.URL.Path, "/services/"):
+		time.Sleep(20 * time.Millisecond)
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		json.NewEncoder(w).Encode(d.service)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestServiceUpdatesRetryOnVersionConflicts(t *testing.T) {
+
+	replicas := uint64(1)
+	daemon := &versionedDaemon{}
+	daemon.service.ID = "1"
+	daemon.service.Spec.Name = "api"
+	daemon.service.Spec.Mode.Replicated = &swarm.ReplicatedService{Replicas: &replicas}
+	daemon.service.Version.Index = 10
+
+	server := httptest.NewServer(daemon)
+	defer server.Close()
+	dockerClient, err := client.NewClient("tcp://"+strings.TrimPrefix(server.URL, "http://"), "1.30", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swarmClient := SwarmClient{DockerClient: dockerClient, Timeout: 5 * time.Second}
+
+	var wg sync.WaitGroup
+	var scaleErr, labelErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, scaleErr = swarmClient.Scale(context.Background(), "api", 3)
+	}()
+	go func() {
+		defer wg.Done()
+		labelErr = swarmClient.UpdateLabel(context.Background(), "1", "caronte.state", "{}")
+	}()
+	wg.Wait()
+
+	if scaleErr != nil || labelErr != nil {
+		t.Fatalf("concurrent updates failed, scale: %v, label: %v", scaleErr, labelErr)
+	}
+	daemon.lock.Lock()
+	defer daemon.lock.Unlock()
+	if daemon.conflicts == 0 {
+		t.Error("the updates did not conflict, the test does not cover the retry")
+	}
+	if got := *daemon.service.Spec.Mode.Replicated.Replicas; got != 3 {
+		t.Errorf("service runs %d replicas, want 3", got)
+	}
+	if daemon.service.Spec.Labels["caronte.state"] != "{}" {
+		t.Error("the state label was lost")
+	}
+}
@@ -0,0 +1,59 @@
+package forecast
+
+// Specs defines how a metric series is bucketed and forecast. Durations are in seconds
+type Specs struct {
+	Interval int
+	Season   int
+	History  int
+	Ahead    int
+	Alpha    float64
+	Beta     float64
+	Gamma    float64
+}
+
+func (s Specs) seasonLength() int {
+	return s.Season / s.Interval
+}
+
+func (s Specs) capacity() int {
+	return s.seasonLength() * s.History
+}
+
+func (s Specs) ahead() int {
+	return (s.Ahead + s.Interval - 1) / s.Interval
+}
+
+// HoltWinters forecasts the value h steps after the last one using additive triple exponential
+// smoothing. At least two full seasons are required to initialise the level, trend and seasonal
+// components
+func HoltWinters(values []float64, seasonLength int, h int, alpha float64, beta float64, gamma float64) (float64, bool) {
+
+	if seasonLength < 1 || h < 1 || len(values) < 2*seasonLength {
+		return 0, false
+	}
+
+	first, second := 0.0, 0.0
+	for i := 0; i < seasonLength; i++ {
+		first += values[i]
+		second += values[seasonLength+i]
+	}
+	first /= float64(seasonLength)
+	second /= float64(seasonLength)
+
+	level := first
+	trend := (second - first) / float64(seasonLength)
+	seasonal := make([]float64, seasonLength)
+	for i := 0; i < seasonLength; i++ {
+		seasonal[i] = values[i] - first
+	}
+
+	for t := seasonLength; t < len(values); t++ {
+		lastLevel := level
+		i := t % seasonLength
+		level = alpha*(values[t]-seasonal[i]) + (1-alpha)*(level+trend)
+		trend = beta*(level-lastLevel) + (1-beta)*trend
+		seasonal[i] = gamma*(values[t]-level) + (1-gamma)*seasonal[i]
+	}
+
+	return level + float64(h)*trend + seasonal[(len(values)-1+h)%seasonLength], true
+}
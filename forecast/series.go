@@ -0,0 +1,163 @@
+package forecast
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Series keeps the observed values of a metric averaged into fixed interval buckets
+type Series struct {
+	Interval    int64     `json:"interval"`
+	FirstBucket int64     `json:"firstBucket"`
+	Values      []float64 `json:"values"`
+	Bucket      int64     `json:"bucket"`
+	Sum         float64   `json:"sum"`
+	Count       int       `json:"count"`
+}
+
+var dataDir string
+var series = make(map[string]*Series)
+var seriesLock sync.Mutex
+
+// SetDataDir defines the directory where the series are persisted. An empty directory keeps
+// the series only in memory
+func SetDataDir(dir string) {
+	if dir != "" {
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			zap.S().Warnf("Forecast data directory %s not available, series are kept in memory: %s", dir, err)
+			return
+		}
+	}
+	dataDir = dir
+}
+
+// Observe adds a value to the series of the key and returns the series forecast the given
+// duration ahead. The forecast is only available once the series holds two full seasons
+func Observe(key string, now time.Time, value float64, specs Specs) (float64, bool) {
+
+	if specs.Interval <= 0 || specs.seasonLength() < 1 || specs.History < 2 {
+		return 0, false
+	}
+
+	seriesLock.Lock()
+	defer seriesLock.Unlock()
+
+	s, contains := series[key]
+	if !contains || s.Interval != int64(specs.Interval) {
+		s = load(key, specs)
+		series[key] = s
+	}
+
+	if s.add(now, value, specs.capacity()) {
+		s.save(key)
+	}
+
+	return HoltWinters(s.Values, specs.seasonLength(), specs.ahead(), specs.Alpha, specs.Beta, specs.Gamma)
+}
+
+// Forget drops the series of the key from memory, the persisted file is kept
+func Forget(key string) {
+	seriesLock.Lock()
+	defer seriesLock.Unlock()
+
+	delete(series, key)
+}
+
+// add records the value into its bucket and returns true when a bucket has been closed
+func (s *Series) add(now time.Time, value float64, capacity int) bool {
+
+	bucket := now.Unix() / s.Interval
+
+	if s.Count == 0 || bucket == s.Bucket {
+		if s.Count == 0 {
+			s.Bucket = bucket
+		}
+		s.Sum += value
+		s.Count++
+		return false
+	}
+
+	if bucket < s.Bucket {
+		return false
+	}
+
+	average := s.Sum / float64(s.Count)
+	if len(s.Values) == 0 {
+		s.FirstBucket = s.Bucket
+	} else {
+		//Fill the buckets without samples with the last known value
+		last := s.Values[len(s.Values)-1]
+		for i := s.FirstBucket + int64(len(s.Values)); i < s.Bucket; i++ {
+			s.Values = append(s.Values, last)
+		}
+	}
+	s.Values = append(s.Values, average)
+
+	if len(s.Values) > capacity {
+		s.FirstBucket += int64(len(s.Values) - capacity)
+		s.Values = s.Values[len(s.Values)-capacity:]
+	}
+
+	s.Bucket = bucket
+	s.Sum = value
+	s.Count = 1
+	return true
+}
+
+func load(key string, specs Specs) *Series {
+
+	s := &Series{Interval: int64(specs.Interval)}
+	if dataDir == "" {
+		return s
+	}
+
+	content, err := ioutil.ReadFile(path(key))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			zap.S().Warn(err)
+		}
+		return s
+	}
+
+	var persisted Series
+	err = json.Unmarshal(content, &persisted)
+	if err != nil || persisted.Interval != s.Interval {
+		zap.S().Warnf("Discarding forecast series %s", key)
+		return s
+	}
+
+	return &persisted
+}
+
+func (s *Series) save(key string) {
+
+	if dataDir == "" {
+		return
+	}
+
+	content, err := json.Marshal(s)
+	if err != nil {
+		zap.S().Error(err)
+		return
+	}
+
+	tmp := path(key) + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0644)
+	if err == nil {
+		err = os.Rename(tmp, path(key))
+	}
+	if err != nil {
+		zap.S().Error(err)
+	}
+}
+
+func path(key string) string {
+	return filepath.Join(dataDir, filepath.Base(key)+".json")
+}
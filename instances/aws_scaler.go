@@ -1,6 +1,7 @@
 package instances
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"sync"
@@ -15,7 +16,10 @@ import (
 var asg *autoscaling.AutoScaling
 var autoScalingSession sync.Once
 
-func (a AwsScale) Scale(scaleSpecs ScaleSpecs, direction int) bool {
+func (a AwsScale) Scale(ctx context.Context, scaleSpecs ScaleSpecs, direction int) bool {
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 
 	autoScalingSession.Do(func() {
 		sess := session.Must(session.NewSessionWithOptions(session.Options{
@@ -25,7 +29,7 @@ func (a AwsScale) Scale(scaleSpecs ScaleSpecs, direction int) bool {
 		asg = autoscaling.New(sess)
 	})
 
-	autoscalingGroup, err := a.getAsgByTags(scaleSpecs)
+	autoscalingGroup, err := a.getAsgByTags(ctx, scaleSpecs)
 	if err != nil {
 		zap.S().Error(err)
 		return false
@@ -33,7 +37,7 @@ func (a AwsScale) Scale(scaleSpecs ScaleSpecs, direction int) bool {
 
 	//Only one scaling Group is allowed, it is needed that the filter returns only one
 	if len(autoscalingGroup.Tags) == 1 {
-		asg, err := a.getAsgByName(autoscalingGroup.Tags[0].ResourceId)
+		asg, err := a.getAsgByName(ctx, autoscalingGroup.Tags[0].ResourceId)
 		if err != nil {
 			zap.S().Error(err)
 			return false
@@ -45,12 +49,12 @@ func (a AwsScale) Scale(scaleSpecs ScaleSpecs, direction int) bool {
 			currentCapacity := *targetAsg.DesiredCapacity
 			desiredCapacity := *targetAsg.DesiredCapacity + int64(direction*1)
 
-			if a.pendingActivityTasks(targetAsg.AutoScalingGroupName) {
+			if a.pendingActivityTasks(ctx, targetAsg.AutoScalingGroupName) {
 				return false
 			}
 
 			if desiredCapacity <= *targetAsg.MaxSize && desiredCapacity >= *targetAsg.MinSize {
-				_, err := a.SetDesiredCapacity(targetAsg.AutoScalingGroupName, desiredCapacity)
+				_, err := a.SetDesiredCapacity(ctx, targetAsg.AutoScalingGroupName, desiredCapacity)
 
 				if err != nil {
 					if aerr, ok := err.(awserr.Error); ok {
@@ -73,7 +77,10 @@ func (a AwsScale) Scale(scaleSpecs ScaleSpecs, direction int) bool {
 	return false
 }
 
-func (a AwsScale) RunningInstances(scaleSpecs ScaleSpecs) int {
+func (a AwsScale) RunningInstances(ctx context.Context, scaleSpecs ScaleSpecs) int {
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 
 	autoScalingSession.Do(func() {
 		sess := session.Must(session.NewSessionWithOptions(session.Options{
@@ -83,14 +90,14 @@ func (a AwsScale) RunningInstances(scaleSpecs ScaleSpecs) int {
 		asg = autoscaling.New(sess)
 	})
 
-	autoscalingGroup, err := a.getAsgByTags(scaleSpecs)
+	autoscalingGroup, err := a.getAsgByTags(ctx, scaleSpecs)
 	if err != nil {
 		zap.S().Error(err)
 	}
 
 	if autoscalingGroup.Tags != nil && len(autoscalingGroup.Tags) == 1 {
 
-		asg, _ := a.getAsgByName(autoscalingGroup.Tags[0].ResourceId)
+		asg, _ := a.getAsgByName(ctx, autoscalingGroup.Tags[0].ResourceId)
 		if len(asg.AutoScalingGroups) == 1 {
 			targetAsg := *asg.AutoScalingGroups[0]
 			return len(targetAsg.Instances)
@@ -100,9 +107,9 @@ func (a AwsScale) RunningInstances(scaleSpecs ScaleSpecs) int {
 	return 0
 }
 
-func (a AwsScale) pendingActivityTasks(name *string) bool {
+func (a AwsScale) pendingActivityTasks(ctx context.Context, name *string) bool {
 	//https://docs.aws.amazon.com/autoscaling/ec2/userguide/AutoScalingGroupLifecycle.html
-	currentActivities, err := a.getAsgActivities(name)
+	currentActivities, err := a.getAsgActivities(ctx, name)
 	if err != nil {
 		zap.S().Error(err)
 		return true
@@ -136,7 +143,7 @@ func (a AwsScale) PendingTasks(asg *autoscaling.DescribeAutoScalingGroupsOutput)
 	return false
 }
 
-func (a AwsScale) SetDesiredCapacity(name *string, capacity int64) (*autoscaling.SetDesiredCapacityOutput, error) {
+func (a AwsScale) SetDesiredCapacity(ctx context.Context, name *string, capacity int64) (*autoscaling.SetDesiredCapacityOutput, error) {
 
 	input := &autoscaling.SetDesiredCapacityInput{
 		AutoScalingGroupName: aws.String(*name),
@@ -144,12 +151,12 @@ func (a AwsScale) SetDesiredCapacity(name *string, capacity int64) (*autoscaling
 		HonorCooldown:        aws.Bool(true),
 	}
 
-	result, err := asg.SetDesiredCapacity(input)
+	result, err := asg.SetDesiredCapacityWithContext(ctx, input)
 
 	return result, err
 }
 
-func (a AwsScale) getAsgByTags(scaleSpecs ScaleSpecs) (*autoscaling.DescribeTagsOutput, error) {
+func (a AwsScale) getAsgByTags(ctx context.Context, scaleSpecs ScaleSpecs) (*autoscaling.DescribeTagsOutput, error) {
 
 	if scaleSpecs.Aws.Filters == "" {
 		return nil, errors.New("missing aws filters values")
@@ -166,7 +173,7 @@ func (a AwsScale) getAsgByTags(scaleSpecs ScaleSpecs) (*autoscaling.DescribeTags
 		Filters: filters,
 	}
 
-	result, err := asg.DescribeTags(input)
+	result, err := asg.DescribeTagsWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -174,13 +181,13 @@ func (a AwsScale) getAsgByTags(scaleSpecs ScaleSpecs) (*autoscaling.DescribeTags
 	return result, nil
 }
 
-func (a AwsScale) getAsgActivities(name *string) (*autoscaling.DescribeScalingActivitiesOutput, error) {
+func (a AwsScale) getAsgActivities(ctx context.Context, name *string) (*autoscaling.DescribeScalingActivitiesOutput, error) {
 
 	input := &autoscaling.DescribeScalingActivitiesInput{
 		AutoScalingGroupName: aws.String(*name),
 	}
 
-	result, err := asg.DescribeScalingActivities(input)
+	result, err := asg.DescribeScalingActivitiesWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -188,7 +195,7 @@ func (a AwsScale) getAsgActivities(name *string) (*autoscaling.DescribeScalingAc
 	return result, nil
 }
 
-func (a AwsScale) getAsgByName(name *string) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+func (a AwsScale) getAsgByName(ctx context.Context, name *string) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
 
 	input := &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{
@@ -196,7 +203,7 @@ func (a AwsScale) getAsgByName(name *string) (*autoscaling.DescribeAutoScalingGr
 		},
 	}
 
-	result, err := asg.DescribeAutoScalingGroups(input)
+	result, err := asg.DescribeAutoScalingGroupsWithContext(ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -1,15 +1,15 @@
 package instances
 
 import (
+	"context"
 	"errors"
 	"time"
 )
 
 type ScaleSpecs struct {
-	Provider  string
-	CoolDown  int
-	UpdatedAt time.Time
-	Aws       AwsScale
+	Provider string
+	CoolDown int
+	Aws      AwsScale
 }
 
 type AwsScale struct {
@@ -22,10 +22,13 @@ type InstanceProviderManager struct {
 }
 
 type InstanceManagerProvider interface {
-	Scale(scaleSpecs ScaleSpecs, direction int) bool
-	RunningInstances(scaleSpecs ScaleSpecs) int
+	Scale(ctx context.Context, scaleSpecs ScaleSpecs, direction int) bool
+	RunningInstances(ctx context.Context, scaleSpecs ScaleSpecs) int
 }
 
+// Timeout bounds every instance provider call
+var Timeout = 30 * time.Second
+
 type InstanceManager interface {
 	GetProvider(provider string) (InstanceManagerProvider, error)
 }
@@ -1,14 +1,25 @@
 package main
 
 import (
+	"Caronte/api"
 	"Caronte/dashboard"
+	"Caronte/engine"
+	"Caronte/forecast"
 	scheduler "Caronte/helper"
+	"Caronte/instances"
 	"Caronte/metrics_publisher"
+	"Caronte/metricstores"
 	"Caronte/orchestrator/discovery"
+	"Caronte/orchestrator/leader"
+	"Caronte/orchestrator/scaler"
+	"Caronte/simulation"
+	"Caronte/statestores"
 	"context"
 	"flag"
+	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"go.uber.org/zap"
@@ -18,14 +29,35 @@ var logger *zap.Logger
 
 func main() {
 
-	ctx := context.Background()
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(simulation.Command(os.Args[2:], os.Stdout))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	logLevel := flag.String("log.level", "INFO", "Define Log level {DEBUG or PROD}. Default value prod")
 	enableDashboard := flag.Bool("dashboard", false, "Activate Dashboard")
 	dashboardPort := flag.Int("dashboard.port", 80, "Dashboard port listener")
-	schedulerDiscoveryTime := flag.Int("service.scheduler.discovery.time", 30, "Seconds to raise scale logic")
+	enableAPI := flag.Bool("api", false, "Activate the JSON control API")
+	apiPort := flag.Int("api.port", 8080, "Control API port listener")
+	targetTolerance := flag.Float64("target.tolerance", 0.1, "Relative distance to the target within which the target policy keeps the replicas")
+	dryRun := flag.Bool("dry-run", false, "Take the scaling decisions without scaling services or instances")
+	schedulerDiscoveryTime := flag.Int("service.scheduler.discovery.time", 30, "Seconds between full service discovery resyncs")
 	sqsMetricPublisherQueuename := flag.String("sqs.metic.publisher.queue.name", "", "")
 	sqsMetricPublisherQueueTime := flag.Int("sqs.metic.publisher.queue.time", 5, "")
+	highAvailability := flag.Bool("ha", false, "Activate HA mode, only the Caronte instance running on the swarm leader scales services")
+	highAvailabilityCheckTime := flag.Int("ha.check.time", 5, "Seconds between swarm leadership checks in HA mode")
+	stateStoreKind := flag.String("state.store", "", "Persist the services scaling state {file or labels}. Default value none")
+	stateFileDir := flag.String("state.file.dir", "/var/lib/caronte/state", "Directory of the file state store")
+	stateLabelsInterval := flag.Int("state.labels.interval", 300, "Minimum seconds between label writes of the stabilization history and pid memory")
+	decisionHistorySize := flag.Int("decisions.history.size", 100, "Decision records kept per service")
+	predictiveDataDir := flag.String("predictive.data.dir", "/var/lib/caronte/forecast", "Directory where the predictive metric series are persisted")
+	dockerTimeout := flag.Int("docker.timeout", 30, "Seconds before a Docker API call is abandoned")
+	dockerStatsPort := flag.Int("docker.stats.port", 0, "Docker API port of the swarm nodes, used to read the stats of their containers")
+	metricTimeout := flag.Int("metric.timeout", 10, "Seconds before a metric store query is abandoned")
+	instanceTimeout := flag.Int("instance.timeout", 30, "Seconds before an instance provider call is abandoned")
+	shutdownTimeout := flag.Int("shutdown.timeout", 30, "Seconds the shutdown waits for in-flight scaling actions and HTTP requests")
 
 	flag.Parse()
 
@@ -42,29 +74,97 @@ func main() {
 
 	zap.S().Info("Caronte init")
 
+	engine.Timeout = time.Second * time.Duration(*dockerTimeout)
+	engine.StatsPort = *dockerStatsPort
+	if engine.StatsPort > 0 && os.Getenv("DOCKER_CERT_PATH") == "" {
+		zap.S().Warn("docker.stats.port needs the TLS settings of DOCKER_CERT_PATH, the stats of the containers of other nodes will not be read")
+	}
+	metricstores.Timeout = time.Second * time.Duration(*metricTimeout)
+	instances.Timeout = time.Second * time.Duration(*instanceTimeout)
+	forecast.SetDataDir(*predictiveDataDir)
+	scaler.DecisionHistorySize = *decisionHistorySize
+	scaler.DryRun = *dryRun
+	scaler.TargetTolerance = *targetTolerance
+	if *dryRun {
+		zap.S().Info("Dry run mode, services and instances are not scaled")
+	}
+
+	statestores.LabelsWriteInterval = time.Second * time.Duration(*stateLabelsInterval)
+	if *stateStoreKind != "" {
+		stateStore, err := statestores.NewStateStore(*stateStoreKind, *stateFileDir)
+		if err == nil {
+			scaler.UseStateStore(stateStore)
+		} else {
+			zap.S().Error(err)
+		}
+	}
+
+	if *highAvailability {
+		swarmEngine, err := engine.NewSwarm()
+		if err == nil {
+			leader.Watch(ctx, swarmEngine, time.Second*time.Duration(*highAvailabilityCheckTime))
+		} else {
+			zap.S().Error(err)
+		}
+	}
+
 	//Init Scheduled Routines
 	worker := scheduler.NewScheduler()
 
 	//Init Caronte Service Discovery
-	serviceDiscovery, err := discovery.NewDiscovery()
+	serviceDiscovery, err := discovery.NewDiscovery(ctx)
 	if err == nil {
 		serviceDiscovery.CaronteServiceDiscovery(ctx)
+		serviceDiscovery.WatchServiceEvents(ctx)
 		worker.Add(ctx, serviceDiscovery.CaronteServiceDiscovery, time.Second*time.Duration(*schedulerDiscoveryTime))
 	} else {
 		zap.S().Error(err)
 	}
 
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, os.Interrupt)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 
 	//TODO load metrics dynamicaly
-	go metrics_publisher.Init(2112)
+	//Decision records are served by the metrics listener
+	http.HandleFunc("/decisions/", scaler.DecisionsHandler)
+	servers := []*http.Server{metrics_publisher.Init(2112)}
 	go metrics_publisher.SQSrecordMetrics(*sqsMetricPublisherQueuename, *sqsMetricPublisherQueueTime)
 
 	if *enableDashboard {
-		go dashboard.Dashboard(*dashboardPort)
+		servers = append(servers, dashboard.Dashboard(*dashboardPort))
 	}
 
-	<-quit
+	if *enableAPI && err == nil {
+		servers = append(servers, api.API(*apiPort, serviceDiscovery))
+	}
+
+	sig := <-quit
+	//A second signal terminates Caronte without waiting
+	signal.Stop(quit)
+	zap.S().Infof("Caronte shutting down on %s", sig)
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), time.Second*time.Duration(*shutdownTimeout))
+	defer shutdownCancel()
+
+	//Stop new decisions and wait for the scaling actions in flight
 	worker.Stop()
+	if err := scaler.Drain(shutdownCtx); err != nil {
+		zap.S().Errorf("Scaling actions still in flight on shutdown: %s", err)
+	}
+	cancel()
+	//The service workers only run when the discovery started
+	if err == nil {
+		select {
+		case <-scaler.Stopped():
+		case <-shutdownCtx.Done():
+			zap.S().Error("Service workers still running on shutdown")
+		}
+	}
+
+	for _, server := range servers {
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			zap.S().Error(err)
+		}
+	}
+	zap.S().Info("Caronte stopped")
 }
@@ -5,10 +5,20 @@ import (
 	"net/http"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 )
 
-func Init(port int) {
+// Init serves the metrics on the port until the returned server is shut down
+func Init(port int) *http.Server {
 
 	http.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(fmt.Sprint(":", port), nil)
+	server := &http.Server{Addr: fmt.Sprint(":", port)}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			zap.S().Error(err)
+		}
+	}()
+
+	return server
 }
@@ -0,0 +1,81 @@
+package metrics_publisher
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	serviceMetricValue = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "caronte_service_metric_value",
+		Help: "Last metric value observed by the scaler",
+	}, []string{"service", "metric"})
+)
+var (
+	serviceMetricForecast = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "caronte_service_metric_forecast",
+		Help: "Metric value forecast by the predictive mode",
+	}, []string{"service", "metric"})
+)
+
+func RecordMetricValue(service string, metric string, value float64) {
+	serviceMetricValue.WithLabelValues(service, metric).Set(value)
+}
+
+func RecordMetricForecast(service string, metric string, value float64) {
+	serviceMetricForecast.WithLabelValues(service, metric).Set(value)
+}
+
+// ForgetMetric removes the series of a metric of an unsubscribed service
+func ForgetMetric(service string, metric string) {
+	serviceMetricValue.DeleteLabelValues(service, metric)
+	serviceMetricForecast.DeleteLabelValues(service, metric)
+}
+
+var (
+	dryRunActions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "caronte_dry_run_actions_total",
+		Help: "Scaling actions skipped by the dry run mode",
+	}, []string{"service", "kind", "action"})
+)
+var (
+	dryRunReplicas = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "caronte_dry_run_target_replicas",
+		Help: "Replicas the service would have been scaled to without the dry run mode",
+	}, []string{"service"})
+)
+
+// RecordDryRunAction counts a service or instances scaling action skipped by the dry run mode
+func RecordDryRunAction(service string, kind string, action string) {
+	dryRunActions.WithLabelValues(service, kind, action).Inc()
+}
+
+func RecordDryRunReplicas(service string, replicas int) {
+	dryRunReplicas.WithLabelValues(service).Set(float64(replicas))
+}
+
+// ForgetDryRun removes the dry run series of an unsubscribed service
+func ForgetDryRun(service string) {
+	for _, kind := range []string{"service", "instances"} {
+		for _, action := range []string{"scaleUp", "scaleDown"} {
+			dryRunActions.DeleteLabelValues(service, kind, action)
+		}
+	}
+	dryRunReplicas.DeleteLabelValues(service)
+}
+
+var (
+	serviceConfigErrors = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "caronte_service_config_errors",
+		Help: "Label configuration errors of a service, a service with errors is not scaled",
+	}, []string{"service"})
+)
+
+func RecordServiceErrors(service string, errors int) {
+	serviceConfigErrors.WithLabelValues(service).Set(float64(errors))
+}
+
+// ForgetServiceErrors removes the configuration errors series of a removed service
+func ForgetServiceErrors(service string) {
+	serviceConfigErrors.DeleteLabelValues(service)
+}
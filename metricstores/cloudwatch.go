@@ -1,29 +1,53 @@
 package metricstores
 
 import (
+	"context"
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
-	"go.uber.org/zap"
 )
 
 var clw *cloudwatch.CloudWatch
 var cloudWatchSession sync.Once
 
+// ReduceLatest folds the CloudWatch datapoints into the most recent one
+const ReduceLatest = "latest"
+
 type CloudWatchStore interface {
-	Query(specs MetricSpecs) (float64, error)
+	Query(ctx context.Context, specs MetricSpecs) (float64, error)
 }
 
+// MetricCloudWatchStore runs the query expression over the last Range seconds, Period when
+// unset, and folds the datapoints with the Reducer. Queries is a JSON list of metric data
+// queries for metric math, ReturnID is the id of the one whose datapoints are used
 type MetricCloudWatchStore struct {
-	Period int
+	Period   int
+	Range    int
+	Reducer  string
+	Queries  string
+	ReturnID string
+}
+
+// CloudWatchQuery is one entry of the metric data queries, either an expression or a
+// metric statistic
+type CloudWatchQuery struct {
+	ID         string            `json:"id"`
+	Expression string            `json:"expression,omitempty"`
+	Namespace  string            `json:"namespace,omitempty"`
+	MetricName string            `json:"metricName,omitempty"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+	Stat       string            `json:"stat,omitempty"`
+	Period     int               `json:"period,omitempty"`
 }
 
-func (p MetricCloudWatchStore) Query(specs MetricSpecs) (float64, error) {
+func (p MetricCloudWatchStore) Query(ctx context.Context, specs MetricSpecs) (float64, error) {
 	cloudWatchSession.Do(func() {
 		sess := session.Must(session.NewSessionWithOptions(session.Options{
 			SharedConfigState: session.SharedConfigEnable,
@@ -32,32 +56,166 @@ func (p MetricCloudWatchStore) Query(specs MetricSpecs) (float64, error) {
 		clw = cloudwatch.New(sess)
 	})
 
-	var queries []*cloudwatch.MetricDataQuery
+	queries, returnID, err := p.DataQueries(specs.Query)
+	if err != nil {
+		return 0, err
+	}
 
-	queries = append(queries, &cloudwatch.MetricDataQuery{
-		Id:         aws.String(fmt.Sprintf("caronte_%x", md5.Sum([]byte(specs.Query)))),
-		Expression: aws.String(specs.Query),
-		Period:     aws.Int64(int64(specs.AwsStore.Period)),
-	})
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 
-	result, err := clw.GetMetricData(&cloudwatch.GetMetricDataInput{
-		StartTime:         aws.Time(time.Now().Add(-time.Duration(specs.AwsStore.Period) * time.Second)),
-		EndTime:           aws.Time(time.Now()),
+	window := p.Range
+	if window <= 0 {
+		window = p.Period
+	}
+	now := time.Now()
+	input := cloudwatch.GetMetricDataInput{
+		StartTime:         aws.Time(now.Add(-time.Duration(window) * time.Second)),
+		EndTime:           aws.Time(now),
 		MetricDataQueries: queries,
-	})
+		ScanBy:            aws.String(cloudwatch.ScanByTimestampAscending),
+	}
 
-	var total float64
-	if err != nil {
-		zap.S().Debug(err)
-	} else if result.MetricDataResults != nil {
+	var values []float64
+	found := false
+	for {
+		result, err := clw.GetMetricDataWithContext(ctx, &input)
+		if err != nil {
+			return 0, err
+		}
+		for _, data := range result.MetricDataResults {
+			if aws.StringValue(data.Id) != returnID {
+				continue
+			}
+			found = true
+			status := aws.StringValue(data.StatusCode)
+			if status != cloudwatch.StatusCodeComplete && status != cloudwatch.StatusCodePartialData {
+				return 0, fmt.Errorf("cloudwatch query %s: status %s %s", returnID, status, cloudWatchMessages(data.Messages))
+			}
+			for _, value := range data.Values {
+				values = append(values, aws.Float64Value(value))
+			}
+		}
+		if result.NextToken == nil {
+			break
+		}
+		input.NextToken = result.NextToken
+	}
+	if !found {
+		return 0, fmt.Errorf("cloudwatch query %s returned no result", returnID)
+	}
 
-		for _, result := range result.MetricDataResults {
-			for _, value := range result.Values {
-				total += *value
+	reducer := p.Reducer
+	switch reducer {
+	case "":
+		reducer = ReduceAvg
+	case ReduceLatest:
+		reducer = ReduceLast
+	}
+	return reduce(reducer, values)
+}
+
+// DataQueries builds the metric data queries and the id of the returned one. Without Queries
+// the expression is the only query
+func (p MetricCloudWatchStore) DataQueries(expression string) ([]*cloudwatch.MetricDataQuery, string, error) {
+
+	if p.Queries == "" {
+		id := fmt.Sprintf("caronte_%x", md5.Sum([]byte(expression)))
+		query := &cloudwatch.MetricDataQuery{
+			Id:         aws.String(id),
+			Expression: aws.String(expression),
+		}
+		if p.Period > 0 {
+			query.Period = aws.Int64(int64(p.Period))
+		}
+		return []*cloudwatch.MetricDataQuery{query}, id, nil
+	}
+
+	var entries []CloudWatchQuery
+	if err := json.Unmarshal([]byte(p.Queries), &entries); err != nil {
+		return nil, "", fmt.Errorf("cloudwatch queries: %s", err)
+	}
+	if len(entries) == 0 {
+		return nil, "", fmt.Errorf("cloudwatch queries: no query defined")
+	}
+
+	returnID := p.ReturnID
+	if returnID == "" {
+		if len(entries) > 1 {
+			return nil, "", fmt.Errorf("cloudwatch queries: a return id is required with %d queries", len(entries))
+		}
+		returnID = entries[0].ID
+	}
+
+	var queries []*cloudwatch.MetricDataQuery
+	found := false
+	for _, entry := range entries {
+		if entry.ID == "" {
+			return nil, "", fmt.Errorf("cloudwatch queries: every query requires an id")
+		}
+		query := &cloudwatch.MetricDataQuery{
+			Id:         aws.String(entry.ID),
+			ReturnData: aws.Bool(entry.ID == returnID),
+		}
+		found = found || entry.ID == returnID
+
+		period := entry.Period
+		if period <= 0 {
+			period = p.Period
+		}
+		switch {
+		case entry.Expression != "" && entry.MetricName != "":
+			return nil, "", fmt.Errorf("cloudwatch query %s: expression and metric can not be used together", entry.ID)
+		case entry.Expression != "":
+			query.Expression = aws.String(entry.Expression)
+			if period > 0 {
+				query.Period = aws.Int64(int64(period))
+			}
+		case entry.MetricName != "":
+			if entry.Namespace == "" || entry.Stat == "" || period <= 0 {
+				return nil, "", fmt.Errorf("cloudwatch query %s: a metric requires a namespace, a stat and a period", entry.ID)
 			}
-			total = total / float64(len(result.Values))
+			query.MetricStat = &cloudwatch.MetricStat{
+				Metric: &cloudwatch.Metric{
+					Namespace:  aws.String(entry.Namespace),
+					MetricName: aws.String(entry.MetricName),
+					Dimensions: cloudWatchDimensions(entry.Dimensions),
+				},
+				Stat:   aws.String(entry.Stat),
+				Period: aws.Int64(int64(period)),
+			}
+		default:
+			return nil, "", fmt.Errorf("cloudwatch query %s: an expression or a metric is required", entry.ID)
 		}
-		return total, nil
+		queries = append(queries, query)
+	}
+	if !found {
+		return nil, "", fmt.Errorf("cloudwatch queries: return id %s is not a query id", returnID)
+	}
+
+	return queries, returnID, nil
+}
+
+// cloudWatchDimensions sorts the dimensions by name, so the request does not depend on the map order
+func cloudWatchDimensions(dimensions map[string]string) []*cloudwatch.Dimension {
+
+	var names []string
+	for name := range dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var result []*cloudwatch.Dimension
+	for _, name := range names {
+		result = append(result, &cloudwatch.Dimension{Name: aws.String(name), Value: aws.String(dimensions[name])})
+	}
+	return result
+}
+
+func cloudWatchMessages(messages []*cloudwatch.MessageData) string {
+	var result []string
+	for _, message := range messages {
+		result = append(result, aws.StringValue(message.Value))
 	}
-	return total, nil
+	return fmt.Sprint(result)
 }
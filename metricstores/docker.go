@@ -0,0 +1,103 @@
+package metricstores
+
+import (
+	"Caronte/engine"
+	"context"
+	"fmt"
+	"sync"
+)
+
+const (
+	DockerCPU    = "cpu"
+	DockerMemory = "memory"
+
+	DockerReservation = "reservation"
+	DockerLimit       = "limit"
+)
+
+type DockerStore interface {
+	Query(ctx context.Context, specs MetricSpecs) (float64, error)
+}
+
+// MetricDockerStore reads the container stats of the running tasks of the service. The query
+// is the resource (cpu or memory), the value is the usage of every task as a percentage of the
+// service reservation or limit of that resource, aggregated with avg or max
+type MetricDockerStore struct {
+	Aggregate  string
+	RelativeTo string
+}
+
+var dockerSwarmClient engine.SwarmClient
+var dockerSwarmErr error
+var dockerSwarmOnce sync.Once
+
+// dockerSwarm returns the Docker client shared by the stores reading the swarm
+func dockerSwarm() (engine.SwarmClient, error) {
+	dockerSwarmOnce.Do(func() {
+		dockerSwarmClient, dockerSwarmErr = engine.NewSwarm()
+	})
+	return dockerSwarmClient, dockerSwarmErr
+}
+
+func (p MetricDockerStore) Query(ctx context.Context, specs MetricSpecs) (float64, error) {
+
+	swarmClient, err := dockerSwarm()
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	service, err := swarmClient.GetService(ctx, specs.ServiceID)
+	if err != nil {
+		return 0, err
+	}
+
+	relativeTo := p.RelativeTo
+	if relativeTo == "" {
+		relativeTo = DockerReservation
+	}
+	resources := service.Spec.TaskTemplate.Resources
+	var nanoCPUs, memoryBytes int64
+	switch {
+	case resources == nil:
+	case relativeTo == DockerReservation && resources.Reservations != nil:
+		nanoCPUs, memoryBytes = resources.Reservations.NanoCPUs, resources.Reservations.MemoryBytes
+	case relativeTo == DockerLimit && resources.Limits != nil:
+		nanoCPUs, memoryBytes = resources.Limits.NanoCPUs, resources.Limits.MemoryBytes
+	}
+
+	var available float64
+	switch specs.Query {
+	case DockerCPU:
+		available = float64(nanoCPUs) / 1e9
+	case DockerMemory:
+		available = float64(memoryBytes)
+	default:
+		return 0, fmt.Errorf("docker resource %s not supported", specs.Query)
+	}
+	if available <= 0 {
+		return 0, fmt.Errorf("service %s has no %s %s", service.Spec.Name, specs.Query, relativeTo)
+	}
+
+	stats, err := swarmClient.ServiceTaskStats(ctx, specs.ServiceID)
+	if err != nil {
+		return 0, err
+	}
+
+	var utilization []float64
+	for _, task := range stats {
+		used := task.CPU
+		if specs.Query == DockerMemory {
+			used = task.Memory
+		}
+		utilization = append(utilization, used/available*100)
+	}
+
+	aggregate := p.Aggregate
+	if aggregate == "" {
+		aggregate = ReduceAvg
+	}
+	return reduce(aggregate, utilization)
+}
@@ -1,21 +1,26 @@
 package metricstores
 
 import (
+	"context"
 	"errors"
+	"time"
 )
 
 type MetricSpecs struct {
+	ServiceID       string
 	Store           string
 	Query           string
 	PrometheusStore MetricPrometheusStore
 	AwsStore        MetricCloudWatchStore
 	SQSStore        MetricSQSStore
+	DockerStore     MetricDockerStore
 }
 
 const (
 	Prometheus = "prometheus"
 	CloudWatch = "cloudwatch"
 	SQS        = "sqs"
+	Docker     = "docker"
 )
 
 type MetricProviderStore struct {
@@ -26,22 +31,23 @@ type MetricStore interface {
 }
 
 type MetricProvider interface {
-	Query(specs MetricSpecs) (float64, error)
+	Query(ctx context.Context, specs MetricSpecs) (float64, error)
 }
 
+// Timeout bounds every metric store query
+var Timeout = 10 * time.Second
+
 func (m MetricProviderStore) GetProvider(specs MetricSpecs) (MetricProvider, error) {
 
 	switch specs.Store {
 	case Prometheus:
-		return MetricPrometheusStore{
-			specs.PrometheusStore.Address,
-		}, nil
+		return specs.PrometheusStore, nil
 	case CloudWatch:
-		return MetricCloudWatchStore{
-			specs.AwsStore.Period,
-		}, nil
+		return specs.AwsStore, nil
 	case SQS:
-		return MetricSQSStore{}, nil
+		return specs.SQSStore, nil
+	case Docker:
+		return specs.DockerStore, nil
 	}
 
 	return nil, errors.New("metric provided required")
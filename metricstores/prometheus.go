@@ -2,42 +2,72 @@ package metricstores
 
 import (
 	"context"
-	"sync"
+	"fmt"
+	"math"
 	"time"
 
-	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 	"go.uber.org/zap"
 )
 
-var client api.Client
-var v1api v1.API
-var prometheusClient sync.Once
-
 type PrometheusStore interface {
-	Query(specs MetricSpecs) (float64, error)
+	Query(ctx context.Context, specs MetricSpecs) (float64, error)
 }
 
+// MetricPrometheusStore queries the Prometheus at Address. The credentials and certificates
+// are the names of Docker secrets mounted in the Caronte container. A query returning several
+// series needs an Aggregate, and with a Range the query is run over the last Range seconds and
+// the points of every series are folded with the Reducer
 type MetricPrometheusStore struct {
-	Address string
+	Address            string
+	Username           string
+	PasswordSecret     string
+	BearerTokenSecret  string
+	CASecret           string
+	CertSecret         string
+	KeySecret          string
+	ServerName         string
+	InsecureSkipVerify bool
+	Aggregate          string
+	Range              int
+	RangeStep          int
+	Reducer            string
 }
 
-func (p MetricPrometheusStore) Query(specs MetricSpecs) (float64, error) {
+// defaultRangeStep is the resolution of a range query without RangeStep
+const defaultRangeStep = 60
 
-	prometheusClient.Do(func() {
-		cli, err := api.NewClient(api.Config{Address: specs.PrometheusStore.Address})
-		if err != nil {
-			zap.S().Error(err)
-		}
-		client = cli
-		v1api = v1.NewAPI(client)
-	})
+func (p MetricPrometheusStore) Query(ctx context.Context, specs MetricSpecs) (float64, error) {
+
+	client, err := prometheusClients.get(p.connection())
+	if err != nil {
+		return 0, err
+	}
+	v1api := v1.NewAPI(client)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
 	defer cancel()
 
-	result, warnings, err := v1api.Query(ctx, specs.Query, time.Now())
+	var result model.Value
+	var warnings v1.Warnings
+	now := time.Now()
+	if p.Range > 0 {
+		step := p.RangeStep
+		if step <= 0 {
+			step = defaultRangeStep
+		}
+		if step > p.Range {
+			step = p.Range
+		}
+		result, warnings, err = v1api.QueryRange(ctx, specs.Query, v1.Range{
+			Start: now.Add(-time.Duration(p.Range) * time.Second),
+			End:   now,
+			Step:  time.Duration(step) * time.Second,
+		})
+	} else {
+		result, warnings, err = v1api.Query(ctx, specs.Query, now)
+	}
 
 	if err != nil {
 		return 0, err
@@ -47,13 +77,58 @@ func (p MetricPrometheusStore) Query(specs MetricSpecs) (float64, error) {
 		zap.S().Warn(warnings)
 	}
 
-	vector := result.(model.Vector)
-	if len(vector) == 1 {
-		return float64(vector[0].Value), nil
+	return p.value(result)
+}
+
+// value turns the query result into one value. Every series of a matrix is first folded
+// with the reducer, then the series are aggregated
+func (p MetricPrometheusStore) value(result model.Value) (float64, error) {
+
+	if result == nil {
+		return 0, ErrNoData
 	}
 
-	zap.S().Debugf("prometheus query return invalid data")
-	zap.S().Debugf("Query result %g", vector)
+	var series []float64
+	switch result := result.(type) {
+	case *model.Scalar:
+		series = append(series, float64(result.Value))
+	case model.Vector:
+		for _, sample := range result {
+			series = append(series, float64(sample.Value))
+		}
+	case model.Matrix:
+		reducer := p.Reducer
+		if reducer == "" {
+			reducer = ReduceAvg
+		}
+		for _, stream := range result {
+			var values []float64
+			for _, pair := range stream.Values {
+				values = append(values, float64(pair.Value))
+			}
+			value, err := reduce(reducer, values)
+			if err == ErrNoData {
+				continue
+			} else if err != nil {
+				return 0, err
+			}
+			series = append(series, value)
+		}
+	default:
+		return 0, fmt.Errorf("prometheus result type %s not supported", result.Type())
+	}
 
-	return 0, nil
+	if p.Aggregate != "" {
+		return reduce(p.Aggregate, series)
+	}
+	if len(series) == 0 {
+		return 0, ErrNoData
+	}
+	if len(series) > 1 {
+		return 0, fmt.Errorf("prometheus query returned %d series, set an aggregate to combine them", len(series))
+	}
+	if math.IsNaN(series[0]) {
+		return 0, ErrNoData
+	}
+	return series[0], nil
 }
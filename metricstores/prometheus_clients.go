@@ -0,0 +1,140 @@
+package metricstores
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/config"
+)
+
+// SecretsDir is the directory where Docker mounts the secrets of the Caronte service
+var SecretsDir = "/run/secrets"
+
+// PrometheusClientIdleTimeout is the time a Prometheus client is kept without being used
+var PrometheusClientIdleTimeout = 10 * time.Minute
+
+// prometheusClient is a cached client of a Prometheus address and its credentials
+type prometheusClient struct {
+	client   api.Client
+	closer   interface{ CloseIdleConnections() }
+	lastUsed time.Time
+}
+
+// prometheusClientCache keeps a client per Prometheus store, evicting the ones not used
+// within PrometheusClientIdleTimeout
+type prometheusClientCache struct {
+	lock    sync.Mutex
+	clients map[MetricPrometheusStore]*prometheusClient
+}
+
+var prometheusClients = prometheusClientCache{clients: make(map[MetricPrometheusStore]*prometheusClient)}
+
+func (c *prometheusClientCache) get(store MetricPrometheusStore) (api.Client, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	c.evict(now)
+
+	cached, contains := c.clients[store]
+	if !contains {
+		//Checked here and not with the labels, which are also validated away from the swarm
+		for label, secret := range store.Secrets() {
+			if _, err := os.Stat(SecretPath(secret)); err != nil {
+				return nil, fmt.Errorf("%s %s is not a secret mounted in the Caronte service", label, secret)
+			}
+		}
+		httpConfig := store.httpConfig()
+		if err := httpConfig.Validate(); err != nil {
+			return nil, err
+		}
+		roundTripper, err := config.NewRoundTripperFromConfig(httpConfig, "caronte", false)
+		if err != nil {
+			return nil, err
+		}
+		client, err := api.NewClient(api.Config{Address: store.Address, RoundTripper: roundTripper})
+		if err != nil {
+			return nil, err
+		}
+		cached = &prometheusClient{client: client}
+		cached.closer, _ = roundTripper.(interface{ CloseIdleConnections() })
+		c.clients[store] = cached
+	}
+	cached.lastUsed = now
+
+	return cached.client, nil
+}
+
+// evict drops the clients idle for longer than PrometheusClientIdleTimeout
+func (c *prometheusClientCache) evict(now time.Time) {
+	for store, cached := range c.clients {
+		if now.Sub(cached.lastUsed) > PrometheusClientIdleTimeout {
+			if cached.closer != nil {
+				cached.closer.CloseIdleConnections()
+			}
+			delete(c.clients, store)
+		}
+	}
+}
+
+// httpConfig maps the store credentials to the Prometheus HTTP client configuration. Secrets
+// are read from their files on every request, so rotated secrets are picked up
+func (p MetricPrometheusStore) httpConfig() config.HTTPClientConfig {
+
+	httpConfig := config.HTTPClientConfig{
+		TLSConfig: config.TLSConfig{
+			CAFile:             SecretPath(p.CASecret),
+			CertFile:           SecretPath(p.CertSecret),
+			KeyFile:            SecretPath(p.KeySecret),
+			ServerName:         p.ServerName,
+			InsecureSkipVerify: p.InsecureSkipVerify,
+		},
+		BearerTokenFile: SecretPath(p.BearerTokenSecret),
+	}
+	if p.Username != "" {
+		httpConfig.BasicAuth = &config.BasicAuth{
+			Username:     p.Username,
+			PasswordFile: SecretPath(p.PasswordSecret),
+		}
+	}
+
+	return httpConfig
+}
+
+// connection returns the store without its query settings, the key of its cached client
+func (p MetricPrometheusStore) connection() MetricPrometheusStore {
+	p.Aggregate = ""
+	p.Range = 0
+	p.RangeStep = 0
+	p.Reducer = ""
+	return p
+}
+
+// Secrets returns the labels and names of the Docker secrets referenced by the store
+func (p MetricPrometheusStore) Secrets() map[string]string {
+	secrets := map[string]string{
+		"prometheus.basicAuth.passwordSecret": p.PasswordSecret,
+		"prometheus.bearerTokenSecret":        p.BearerTokenSecret,
+		"prometheus.tls.caSecret":             p.CASecret,
+		"prometheus.tls.certSecret":           p.CertSecret,
+		"prometheus.tls.keySecret":            p.KeySecret,
+	}
+	for label, secret := range secrets {
+		if secret == "" {
+			delete(secrets, label)
+		}
+	}
+	return secrets
+}
+
+// SecretPath returns the file of a Docker secret mounted in the Caronte container
+func SecretPath(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return filepath.Join(SecretsDir, secret)
+}
@@ -0,0 +1,64 @@
+package metricstores
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrNoData is returned by the stores when the query has no value, which is not a zero value
+var ErrNoData = errors.New("no data")
+
+const (
+	ReduceSum   = "sum"
+	ReduceAvg   = "avg"
+	ReduceMax   = "max"
+	ReduceMin   = "min"
+	ReduceCount = "count"
+	ReduceLast  = "last"
+)
+
+// reduce folds the values into one with the given reducer, NaN values are ignored
+func reduce(reducer string, values []float64) (float64, error) {
+
+	var valid []float64
+	for _, value := range values {
+		if !math.IsNaN(value) {
+			valid = append(valid, value)
+		}
+	}
+	if reducer == ReduceCount {
+		return float64(len(valid)), nil
+	}
+	if len(valid) == 0 {
+		return 0, ErrNoData
+	}
+
+	switch reducer {
+	case ReduceSum, ReduceAvg:
+		sum := 0.0
+		for _, value := range valid {
+			sum += value
+		}
+		if reducer == ReduceAvg {
+			return sum / float64(len(valid)), nil
+		}
+		return sum, nil
+	case ReduceMax:
+		max := valid[0]
+		for _, value := range valid[1:] {
+			max = math.Max(max, value)
+		}
+		return max, nil
+	case ReduceMin:
+		min := valid[0]
+		for _, value := range valid[1:] {
+			min = math.Min(min, value)
+		}
+		return min, nil
+	case ReduceLast:
+		return valid[len(valid)-1], nil
+	}
+
+	return 0, fmt.Errorf("reducer %s not supported", reducer)
+}
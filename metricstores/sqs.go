@@ -1,29 +1,48 @@
 package metricstores
 
 import (
+	"context"
+	"fmt"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
-	"go.uber.org/zap"
 )
 
 var sqsSession sync.Once
 var targetSQS *sqs.SQS
-var queueUrl *string
+
+// QueueURLCacheTime is the time a resolved queue URL is reused
+var QueueURLCacheTime = time.Hour
 
 type SQSStore interface {
-	Query(specs MetricSpecs) (float64, error)
+	Query(ctx context.Context, specs MetricSpecs) (float64, error)
 }
 
+// MetricSQSStore reads the attributes of the queue named QueueName, or found at QueueUrl. The
+// query is a comma separated list of attributes whose values are added, with PerReplica the
+// total is divided by the running replicas of the service
 type MetricSQSStore struct {
-	QueueName string
-	QueueUrl  string
+	QueueName  string
+	QueueUrl   string
+	PerReplica bool
+}
+
+// queueURL is a resolved queue URL
+type queueURL struct {
+	url        string
+	resolvedAt time.Time
 }
 
-func (p MetricSQSStore) Query(specs MetricSpecs) (float64, error) {
+var queueURLs = make(map[string]queueURL)
+var queueURLsLock sync.Mutex
+
+func (p MetricSQSStore) Query(ctx context.Context, specs MetricSpecs) (float64, error) {
 
 	sqsSession.Do(func() {
 		sess := session.Must(session.NewSessionWithOptions(session.Options{
@@ -31,34 +50,120 @@ func (p MetricSQSStore) Query(specs MetricSpecs) (float64, error) {
 		}))
 
 		targetSQS = sqs.New(sess)
-
-		result, _ := targetSQS.GetQueueUrl(&sqs.GetQueueUrlInput{
-			QueueName: aws.String(specs.SQSStore.QueueName),
-		})
-		queueUrl = result.QueueUrl
 	})
 
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	url, err := p.queueURL(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var names []*string
+	for _, name := range strings.Split(specs.Query, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, aws.String(name))
+		}
+	}
+	if len(names) == 0 {
+		return 0, fmt.Errorf("sqs queue %s: no attribute to query", p.QueueName)
+	}
 	attributes := sqs.GetQueueAttributesInput{
-		QueueUrl: queueUrl,
-		AttributeNames: []*string{
-			aws.String(specs.Query),
-		},
+		QueueUrl:       aws.String(url),
+		AttributeNames: names,
 	}
 
 	totalValue := 0.0
 	//Fix needed to ensure that the sqs results does not return 0 when the queue contains messages
 	for i := 0; i < 3; i++ {
-		resp, err := targetSQS.GetQueueAttributes(&attributes)
+		resp, err := targetSQS.GetQueueAttributesWithContext(ctx, &attributes)
 		if err != nil {
-			zap.S().Debug(err)
+			if aerr, ok := err.(awserr.Error); ok && aerr.Code() == sqs.ErrCodeQueueDoesNotExist {
+				p.forgetQueueURL()
+			}
+			return 0, err
 		}
 
-		value, _ := strconv.ParseFloat(*resp.Attributes[specs.Query], 64)
+		value := 0.0
+		for _, name := range names {
+			attribute, contains := resp.Attributes[*name]
+			if !contains || attribute == nil {
+				return 0, fmt.Errorf("sqs queue %s: attribute %s not returned", p.QueueName, *name)
+			}
+			parsed, err := strconv.ParseFloat(*attribute, 64)
+			if err != nil {
+				return 0, fmt.Errorf("sqs queue %s: attribute %s: %s", p.QueueName, *name, err)
+			}
+			value += parsed
+		}
 		if totalValue < value {
 			totalValue = value
 		}
-
 	}
 
+	if p.PerReplica {
+		return perReplica(ctx, specs.ServiceID, totalValue)
+	}
 	return totalValue, nil
 }
+
+// queueURL returns the URL of the queue, resolving its name when the URL is not cached
+func (p MetricSQSStore) queueURL(ctx context.Context) (string, error) {
+
+	if p.QueueUrl != "" {
+		return p.QueueUrl, nil
+	}
+	if p.QueueName == "" {
+		return "", fmt.Errorf("sqs queue name or url required")
+	}
+
+	queueURLsLock.Lock()
+	cached, contains := queueURLs[p.QueueName]
+	queueURLsLock.Unlock()
+	if contains && time.Since(cached.resolvedAt) < QueueURLCacheTime {
+		return cached.url, nil
+	}
+
+	result, err := targetSQS.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(p.QueueName),
+	})
+	if err != nil {
+		return "", err
+	}
+	if result.QueueUrl == nil {
+		return "", fmt.Errorf("sqs queue %s not found", p.QueueName)
+	}
+
+	queueURLsLock.Lock()
+	queueURLs[p.QueueName] = queueURL{url: *result.QueueUrl, resolvedAt: time.Now()}
+	queueURLsLock.Unlock()
+
+	return *result.QueueUrl, nil
+}
+
+func (p MetricSQSStore) forgetQueueURL() {
+	queueURLsLock.Lock()
+	defer queueURLsLock.Unlock()
+
+	delete(queueURLs, p.QueueName)
+}
+
+// perReplica divides the value by the running replicas of the service. A service without
+// running replicas gets the whole value, so a backlog still wakes it up
+func perReplica(ctx context.Context, serviceID string, value float64) (float64, error) {
+
+	swarmClient, err := dockerSwarm()
+	if err != nil {
+		return 0, err
+	}
+
+	running, err := swarmClient.RunningTasks(ctx, serviceID)
+	if err != nil {
+		return 0, err
+	}
+	if running < 1 {
+		return value, nil
+	}
+	return value / float64(running), nil
+}
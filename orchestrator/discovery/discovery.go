@@ -3,48 +3,97 @@ package discovery
 import (
 	"Caronte/core"
 	"Caronte/engine"
+	"Caronte/metrics_publisher"
 	"Caronte/orchestrator/scaler"
 	"context"
+	"reflect"
+	"sync"
+	"time"
 
+	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	"go.uber.org/zap"
 )
 
 const caronteEnable = "caronte.enable"
 
+const eventsRetryDelay = 5 * time.Second
+
+// eventsCoalesceTime is the time the service events are gathered before running the discovery,
+// so a burst of events, e.g. a stack deploy or the scaling of Caronte itself, runs it once
+var eventsCoalesceTime = 2 * time.Second
+
+// after starts the timer gathering the service events
+var after = time.After
+
 var activeServices map[string]core.CaronteService
 var serviceChan chan core.CaronteService
 var serviceUnsuscribeChan chan core.CaronteService
+var discoveryLock sync.Mutex
+var activeServicesLock sync.RWMutex
 
+// Discovery subscribes the services labelled caronte.enable to the scaler. It stops handing
+// services to the scaler once the context it was created with is done, as the scaler is
+// stopped with it
 type Discovery struct {
 	SwarmEngine engine.SwarmEngine
+	done        <-chan struct{}
 }
 
+// GetActiveServices returns a copy of the services managed by Caronte
 func GetActiveServices() map[string]core.CaronteService {
-	return activeServices
+	activeServicesLock.RLock()
+	defer activeServicesLock.RUnlock()
+
+	services := make(map[string]core.CaronteService, len(activeServices))
+	for name, service := range activeServices {
+		services[name] = service
+	}
+	return services
 }
 
-func NewDiscovery() (Discovery, error) {
+func NewDiscovery(ctx context.Context) (Discovery, error) {
 	swarmEngine, err := engine.NewSwarm()
 	serviceChan = make(chan core.CaronteService)
 	serviceUnsuscribeChan = make(chan core.CaronteService)
 
 	serviceScale, err := scaler.NewServiceScale()
 	if err == nil {
-		serviceScale.Init(serviceChan, serviceUnsuscribeChan)
+		serviceScale.Init(ctx, serviceChan, serviceUnsuscribeChan)
 	} else {
 		zap.S().Error(err)
 	}
 
-	serviceChan <- core.CaronteService{}
-	return Discovery{SwarmEngine: swarmEngine}, err
+	discovery := Discovery{SwarmEngine: swarmEngine, done: ctx.Done()}
+	if err == nil {
+		discovery.send(ctx, serviceChan, core.CaronteService{})
+	}
+	return discovery, err
+}
+
+// send hands a service to the scaler, returning false when the discovery or the scaler have
+// been stopped before it was received
+func (d Discovery) send(ctx context.Context, channel chan core.CaronteService, service core.CaronteService) bool {
+	select {
+	case channel <- service:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-d.done:
+		return false
+	}
 }
 
 func (d Discovery) CaronteServiceDiscovery(ctx context.Context) {
 
-	services, err := d.SwarmEngine.GetServices(filters.NewArgs(filters.KeyValuePair{Key: "label", Value: caronteEnable}))
+	discoveryLock.Lock()
+	defer discoveryLock.Unlock()
+
+	services, err := d.SwarmEngine.GetServices(ctx, filters.NewArgs(filters.KeyValuePair{Key: "label", Value: caronteEnable}))
 	if err != nil {
+		//Keep the services subscribed until the next discovery
 		zap.S().Error(err)
+		return
 	}
 
 	newServices := make(map[string]core.CaronteService)
@@ -52,10 +101,28 @@ func (d Discovery) CaronteServiceDiscovery(ctx context.Context) {
 
 		if dockerService.Spec.Mode.Global == nil {
 			service := core.NewCaronteService(dockerService.ID, dockerService.Spec.Name, dockerService.Spec.Annotations)
+			if _, registered := scaler.GetPolicy(service.Policy); !registered {
+				service.Errors = append(service.Errors, core.UnsupportedValueError{Label: "caronte.scale.policy", Value: service.Policy, Reason: "is not a registered scaling policy"})
+			}
 
-			if !equals(service, activeServices[service.Name]) {
-				serviceChan <- service
+			previous, known := activeServices[service.Name]
+			if !equals(service, previous) {
+				if len(service.Errors) == 0 {
+					if !d.send(ctx, serviceChan, service) {
+						return
+					}
+				} else {
+					//Invalid services are listed but not scaled until their labels are fixed. Their
+					//scaling state is kept, it is only dropped once the service is removed
+					for _, err := range service.Errors {
+						zap.S().Errorf("Service %s not scaled, %s", service.Name, err)
+					}
+					if known && len(previous.Errors) == 0 && !d.send(ctx, serviceChan, service) {
+						return
+					}
+				}
 			}
+			metrics_publisher.RecordServiceErrors(service.Name, len(service.Errors))
 
 			newServices[service.Name] = service
 		} else {
@@ -65,31 +132,141 @@ func (d Discovery) CaronteServiceDiscovery(ctx context.Context) {
 	for key := range activeServices {
 		_, containes := newServices[key]
 		if !containes {
-			serviceUnsuscribeChan <- activeServices[key]
+			if !d.send(ctx, serviceUnsuscribeChan, activeServices[key]) {
+				return
+			}
+			metrics_publisher.ForgetServiceErrors(key)
 		}
 	}
+	activeServicesLock.Lock()
 	activeServices = newServices
+	activeServicesLock.Unlock()
+
+}
+
+// WatchServiceEvents subscribes to the Docker service events and runs the discovery as soon as
+// a service is created, updated or removed. The periodic discovery is kept as a resync safety net
+func (d Discovery) WatchServiceEvents(ctx context.Context) {
+
+	go func() {
+		for {
+			messages, errs := d.SwarmEngine.Events(ctx, filters.NewArgs(filters.KeyValuePair{Key: "type", Value: events.ServiceEventType}))
+			if !d.consumeServiceEvents(ctx, messages, errs) {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(eventsRetryDelay):
+			}
+			//Events may have been lost while reconnecting
+			d.CaronteServiceDiscovery(ctx)
+		}
+	}()
+}
 
+// consumeServiceEvents runs the discovery for the events until the stream fails, returning
+// false when the context has been cancelled. The events received within eventsCoalesceTime of
+// the first one run a single discovery
+func (d Discovery) consumeServiceEvents(ctx context.Context, messages <-chan events.Message, errs <-chan error) bool {
+	var coalesce <-chan time.Time
+	for {
+		select {
+		case message := <-messages:
+			zap.S().Debugf("Service %s event %s", message.Actor.Attributes["name"], message.Action)
+			if coalesce == nil {
+				coalesce = after(eventsCoalesceTime)
+			}
+		case <-coalesce:
+			coalesce = nil
+			d.CaronteServiceDiscovery(ctx)
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return false
+			}
+			zap.S().Errorf("Docker events stream failed: %s", err)
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
 }
 
 func equals(newService core.CaronteService, service core.CaronteService) bool {
-	if newService.ServiceScheduler == service.ServiceScheduler &&
+	if newService.Id == service.Id &&
+		newService.ServiceScheduler == service.ServiceScheduler &&
 		newService.Max == service.Max &&
 		newService.Min == service.Min &&
 		newService.Step == service.Step &&
+		schedulesEquals(newService.Schedules, service.Schedules) &&
 		newService.ServiceCoolDownDelay == service.ServiceCoolDownDelay &&
+		newService.ScaleDownStabilizationWindow == service.ScaleDownStabilizationWindow &&
 		newService.MaxReplicasPerNode == service.MaxReplicasPerNode &&
-		newService.ScaleUpThreshold == service.ScaleUpThreshold &&
-		newService.ScaleDownThreshold == service.ScaleDownThreshold &&
-		newService.MetricSpecs.Store == service.MetricSpecs.Store &&
-		newService.MetricSpecs.Query == service.MetricSpecs.Query &&
-		newService.MetricSpecs.PrometheusStore.Address == service.MetricSpecs.PrometheusStore.Address &&
-		newService.MetricSpecs.AwsStore.Period == service.MetricSpecs.AwsStore.Period &&
-		newService.MetricSpecs.SQSStore.QueueName == service.MetricSpecs.SQSStore.QueueName &&
+		newService.Policy == service.Policy &&
+		newService.MetricCombine == service.MetricCombine &&
+		metricsEquals(newService.Metrics, service.Metrics) &&
+		newService.Predictive == service.Predictive &&
+		newService.PID == service.PID &&
+		newService.IdleAfter == service.IdleAfter &&
+		newService.ActivationReplicas == service.ActivationReplicas &&
+		newService.DryRun == service.DryRun &&
+		metricsEquals([]core.ServiceMetric{newService.Activation}, []core.ServiceMetric{service.Activation}) &&
 		newService.InstanceSpecs.Provider == service.InstanceSpecs.Provider &&
 		newService.InstanceSpecs.CoolDown == service.InstanceSpecs.CoolDown &&
-		newService.InstanceSpecs.Aws.Filters == service.InstanceSpecs.Aws.Filters {
+		newService.InstanceSpecs.Aws.Filters == service.InstanceSpecs.Aws.Filters &&
+		errorsEquals(newService.Errors, service.Errors) {
 		return true
 	}
 	return false
 }
+
+func errorsEquals(newErrors []error, errors []error) bool {
+	if len(newErrors) != len(errors) {
+		return false
+	}
+	for i := range newErrors {
+		if newErrors[i].Error() != errors[i].Error() {
+			return false
+		}
+	}
+	return true
+}
+
+func schedulesEquals(newSchedules []core.ScheduledCapacity, schedules []core.ScheduledCapacity) bool {
+	if len(newSchedules) != len(schedules) {
+		return false
+	}
+	for i := range newSchedules {
+		if newSchedules[i].Cron != schedules[i].Cron ||
+			newSchedules[i].Min != schedules[i].Min ||
+			newSchedules[i].Max != schedules[i].Max ||
+			newSchedules[i].Timezone != schedules[i].Timezone {
+			return false
+		}
+	}
+	return true
+}
+
+func metricsEquals(newMetrics []core.ServiceMetric, metrics []core.ServiceMetric) bool {
+	if len(newMetrics) != len(metrics) {
+		return false
+	}
+	for i := range newMetrics {
+		if newMetrics[i].Name != metrics[i].Name ||
+			newMetrics[i].Step != metrics[i].Step ||
+			!reflect.DeepEqual(newMetrics[i].Steps, metrics[i].Steps) ||
+			newMetrics[i].ScaleUpThreshold != metrics[i].ScaleUpThreshold ||
+			newMetrics[i].ScaleDownThreshold != metrics[i].ScaleDownThreshold ||
+			newMetrics[i].Target != metrics[i].Target ||
+			newMetrics[i].MetricSpecs.Store != metrics[i].MetricSpecs.Store ||
+			newMetrics[i].MetricSpecs.Query != metrics[i].MetricSpecs.Query ||
+			newMetrics[i].MetricSpecs.PrometheusStore != metrics[i].MetricSpecs.PrometheusStore ||
+			newMetrics[i].MetricSpecs.AwsStore != metrics[i].MetricSpecs.AwsStore ||
+			newMetrics[i].MetricSpecs.SQSStore != metrics[i].MetricSpecs.SQSStore ||
+			newMetrics[i].MetricSpecs.DockerStore != metrics[i].MetricSpecs.DockerStore {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,240 @@
+package discovery
+
+import (
+	"Caronte/core"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// fakeSwarm lists the configured services and counts the discoveries, signalling each of
+// them on listings when set
+type fakeSwarm struct {
+	lock     sync.Mutex
+	services []swarm.Service
+	lists    int
+	listings chan struct{}
+}
+
+func (f *fakeSwarm) NodeID(ctx context.Context) (string, error) { return "node", nil }
+
+func (f *fakeSwarm) IsLeader(ctx context.Context, nodeID string) (bool, error) { return true, nil }
+
+func (f *fakeSwarm) ServiceCurrentReplicas(ctx context.Context, serviceID string) (int, error) {
+	return 1, nil
+}
+
+func (f *fakeSwarm) GetService(ctx context.Context, serviceID string) (swarm.Service, error) {
+	return swarm.Service{}, errors.New("not found")
+}
+
+func (f *fakeSwarm) GetServices(ctx context.Context, args filters.Args) ([]swarm.Service, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.lists++
+	if f.listings != nil {
+		f.listings <- struct{}{}
+	}
+	return f.services, nil
+}
+
+func (f *fakeSwarm) Scale(ctx context.Context, serviceID string, target int) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeSwarm) UpdateLabel(ctx context.Context, serviceID string, key string, value string) error {
+	return nil
+}
+
+func (f *fakeSwarm) OnGoingTasks(ctx context.Context, serviceID string) (int, error) { return 0, nil }
+
+func (f *fakeSwarm) PendingTasks(ctx context.Context, serviceID string) (int, error) { return 0, nil }
+
+func (f *fakeSwarm) RunningTasks(ctx context.Context, serviceID string) (int, error) { return 1, nil }
+
+func (f *fakeSwarm) TotalActiveTasks(ctx context.Context, serviceID string) (int, error) {
+	return 1, nil
+}
+
+func (f *fakeSwarm) Events(ctx context.Context, args filters.Args) (<-chan events.Message, <-chan error) {
+	return nil, nil
+}
+
+func (f *fakeSwarm) listed() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.lists
+}
+
+// setActiveServices replaces the services known by the discovery
+func setActiveServices(services map[string]core.CaronteService) {
+	activeServicesLock.Lock()
+	defer activeServicesLock.Unlock()
+
+	activeServices = services
+}
+
+// receive waits for a value of the channel, failing the test after a while
+func receive(t *testing.T, channel <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-channel:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %s", what)
+	}
+}
+
+func scaledService(id string, name string) swarm.Service {
+	service := swarm.Service{ID: id}
+	service.Spec.Name = name
+	service.Spec.Labels = map[string]string{
+		caronteEnable:                       "true",
+		"caronte.scale.max":                 "5",
+		"caronte.scale.min":                 "1",
+		"caronte.metric.store":              "prometheus",
+		"caronte.metric.prometheus.address": "http://localhost:9090",
+		"caronte.metric.query":              "up",
+		"caronte.metric.scaleUpThreshold":   "50",
+		"caronte.metric.scaleDownThreshold": "10",
+	}
+	return service
+}
+
+func TestDiscoveryReturnsOnceStopped(t *testing.T) {
+
+	setActiveServices(map[string]core.CaronteService{"removed": {Name: "removed"}})
+	serviceChan = make(chan core.CaronteService)
+	serviceUnsuscribeChan = make(chan core.CaronteService)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	swarmEngine := &fakeSwarm{services: []swarm.Service{scaledService("1", "api")}, listings: make(chan struct{}, 1)}
+	d := Discovery{SwarmEngine: swarmEngine, done: ctx.Done()}
+
+	//Nobody receives the services, as after the scaler has stopped
+	done := make(chan struct{})
+	go func() {
+		d.CaronteServiceDiscovery(context.Background())
+		close(done)
+	}()
+
+	receive(t, swarmEngine.listings, "the discovery")
+	cancel()
+	receive(t, done, "the discovery to return after the scaler stopped")
+}
+
+func TestDiscoverySubscribesAndUnsubscribes(t *testing.T) {
+
+	setActiveServices(map[string]core.CaronteService{"removed": {Id: "0", Name: "removed"}})
+	serviceChan = make(chan core.CaronteService, 1)
+	serviceUnsuscribeChan = make(chan core.CaronteService, 1)
+
+	swarmEngine := &fakeSwarm{services: []swarm.Service{scaledService("1", "api")}}
+	d := Discovery{SwarmEngine: swarmEngine}
+	d.CaronteServiceDiscovery(context.Background())
+
+	if subscribed := <-serviceChan; subscribed.Name != "api" {
+		t.Errorf("subscribed %q, want api", subscribed.Name)
+	}
+	if unsubscribed := <-serviceUnsuscribeChan; unsubscribed.Name != "removed" {
+		t.Errorf("unsubscribed %q, want removed", unsubscribed.Name)
+	}
+	if _, contains := GetActiveServices()["api"]; !contains {
+		t.Error("api is not an active service")
+	}
+
+	//An unchanged service is not subscribed again
+	d.CaronteServiceDiscovery(context.Background())
+	select {
+	case service := <-serviceChan:
+		t.Errorf("unchanged service %s subscribed again", service.Name)
+	default:
+	}
+}
+
+func TestInvalidServiceIsNotUnsubscribed(t *testing.T) {
+
+	valid := scaledService("1", "api")
+	invalid := scaledService("1", "api")
+	invalid.Spec.Labels["caronte.scale.max"] = "five"
+
+	setActiveServices(nil)
+	serviceChan = make(chan core.CaronteService, 1)
+	serviceUnsuscribeChan = make(chan core.CaronteService, 1)
+	swarmEngine := &fakeSwarm{services: []swarm.Service{valid}}
+	d := Discovery{SwarmEngine: swarmEngine}
+	d.CaronteServiceDiscovery(context.Background())
+	<-serviceChan
+
+	swarmEngine.lock.Lock()
+	swarmEngine.services = []swarm.Service{invalid}
+	swarmEngine.lock.Unlock()
+	d.CaronteServiceDiscovery(context.Background())
+
+	select {
+	case service := <-serviceUnsuscribeChan:
+		t.Fatalf("invalid service %s unsubscribed, its state would be deleted", service.Name)
+	case service := <-serviceChan:
+		if len(service.Errors) == 0 {
+			t.Error("the invalid service was subscribed without its errors")
+		}
+	default:
+		t.Error("the scaler was not told the service became invalid")
+	}
+}
+
+func TestServiceEventsAreCoalesced(t *testing.T) {
+
+	timers := make(chan chan time.Time, 10)
+	after = func(time.Duration) <-chan time.Time {
+		timer := make(chan time.Time, 1)
+		timers <- timer
+		return timer
+	}
+	defer func() { after = time.After }()
+
+	setActiveServices(nil)
+	swarmEngine := &fakeSwarm{listings: make(chan struct{}, 1)}
+	d := Discovery{SwarmEngine: swarmEngine}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	messages := make(chan events.Message)
+	errs := make(chan error)
+	done := make(chan struct{})
+	go func() {
+		d.consumeServiceEvents(ctx, messages, errs)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		receive(t, done, "the events consumer to return")
+	}()
+
+	//The events are received one by one, so the burst is consumed once the last one is sent
+	for i := 0; i < 20; i++ {
+		messages <- events.Message{Action: "update"}
+	}
+	timer := <-timers
+	timer <- time.Now()
+	receive(t, swarmEngine.listings, "the discovery of the burst")
+	select {
+	case <-timers:
+		t.Error("a burst of events started more than one discovery")
+	default:
+	}
+
+	messages <- events.Message{Action: "update"}
+	timer = <-timers
+	timer <- time.Now()
+	receive(t, swarmEngine.listings, "the discovery of a later event")
+	if lists := swarmEngine.listed(); lists != 2 {
+		t.Errorf("the events ran %d discoveries, want 2", lists)
+	}
+}
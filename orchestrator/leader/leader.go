@@ -0,0 +1,65 @@
+package leader
+
+import (
+	"Caronte/engine"
+	"context"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// leading is 1 while this Caronte instance is allowed to scale. Without HA mode the
+// instance always leads
+var leading int32 = 1
+
+// IsLeader tells whether this Caronte instance executes the scaling decisions
+func IsLeader() bool {
+	return atomic.LoadInt32(&leading) == 1
+}
+
+// Watch enables the HA mode: only the Caronte instance running on the current swarm leader
+// node scales services while the others stay in standby. The leadership is checked every
+// interval so the scaling moves with the swarm leader
+func Watch(ctx context.Context, swarmEngine engine.SwarmEngine, interval time.Duration) {
+
+	atomic.StoreInt32(&leading, 0)
+	check(ctx, swarmEngine)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				check(ctx, swarmEngine)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func check(ctx context.Context, swarmEngine engine.SwarmEngine) {
+
+	isLeader := false
+	nodeID, err := swarmEngine.NodeID(ctx)
+	if err == nil {
+		isLeader, err = swarmEngine.IsLeader(ctx, nodeID)
+	}
+	if err != nil && ctx.Err() == nil {
+		zap.S().Errorf("Fail checking the swarm leadership: %s", err)
+	}
+
+	value := int32(0)
+	if isLeader {
+		value = 1
+	}
+	if atomic.SwapInt32(&leading, value) != value {
+		if isLeader {
+			zap.S().Infof("Node %s is the swarm leader, Caronte is now active", nodeID)
+		} else {
+			zap.S().Infof("Node %s is not the swarm leader, Caronte is now in standby", nodeID)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"Caronte/orchestrator/leader"
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrStandby is returned by the control changes on a Caronte instance not running on the
+// swarm leader, as its changes would be replaced by the state of the leading instance
+var ErrStandby = errors.New("Caronte is not running on the swarm leader, send the request to the leading instance")
+
+// ServiceStatus is the live scaling state of a service
+type ServiceStatus struct {
+	Paused                bool            `json:"paused"`
+	ManualReplicas        *int            `json:"manualReplicas,omitempty"`
+	ManualUntil           *time.Time      `json:"manualUntil,omitempty"`
+	LastAction            string          `json:"lastAction,omitempty"`
+	LastActionAt          *time.Time      `json:"lastActionAt,omitempty"`
+	CoolDownUntil         *time.Time      `json:"coolDownUntil,omitempty"`
+	InstanceCoolDownUntil *time.Time      `json:"instanceCoolDownUntil,omitempty"`
+	IdleSince             *time.Time      `json:"idleSince,omitempty"`
+	LastDecision          *DecisionRecord `json:"lastDecision,omitempty"`
+}
+
+// Pause stops the autoscaling of a service, its ticks are recorded but take no action
+func Pause(ctx context.Context, service core.CaronteService) error {
+	return control(ctx, service, func(state *serviceState) {
+		state.paused = true
+	})
+}
+
+// Resume restarts the autoscaling of a paused service
+func Resume(ctx context.Context, service core.CaronteService) error {
+	return control(ctx, service, func(state *serviceState) {
+		state.paused = false
+	})
+}
+
+// ForceReplicas keeps the service at the replicas for the ttl, ignoring its metrics, Min, Max
+// and cool downs. Autoscaling resumes once the ttl expires
+func ForceReplicas(ctx context.Context, service core.CaronteService, replicas int, ttl time.Duration) error {
+	return control(ctx, service, func(state *serviceState) {
+		state.manualReplicas = replicas
+		state.manualUntil = clock().Add(ttl)
+	})
+}
+
+// ClearReplicas drops the forced replicas of a service
+func ClearReplicas(ctx context.Context, service core.CaronteService) error {
+	return control(ctx, service, func(state *serviceState) {
+		state.manualReplicas = 0
+		state.manualUntil = time.Time{}
+	})
+}
+
+// control applies a control change on the leading instance. The persisted state is restored
+// first so it does not replace the change later, and the change is saved at once
+func control(ctx context.Context, service core.CaronteService, change func(state *serviceState)) error {
+
+	if !leader.IsLeader() {
+		return ErrStandby
+	}
+	if err := restoreState(ctx, service); err != nil {
+		return err
+	}
+	withState(service.Name, change)
+	return persistState(ctx, service)
+}
+
+// manualReplicas returns the forced replicas of the service while they have not expired
+func manualReplicas(name string, now time.Time) (int, bool) {
+	replicas, forced := 0, false
+	withState(name, func(state *serviceState) {
+		if state.manualUntil.IsZero() {
+			return
+		}
+		if now.After(state.manualUntil) {
+			state.manualReplicas = 0
+			state.manualUntil = time.Time{}
+			return
+		}
+		replicas, forced = state.manualReplicas, true
+	})
+	return replicas, forced
+}
+
+// paused reports whether the autoscaling of the service is paused
+func paused(name string) bool {
+	paused := false
+	withState(name, func(state *serviceState) {
+		paused = state.paused
+	})
+	return paused
+}
+
+// Status returns the live scaling state of a service
+func Status(name string) ServiceStatus {
+	var status ServiceStatus
+	withState(name, func(state *serviceState) {
+		status.Paused = state.paused
+		status.LastAction = state.lastAction
+		if !state.manualUntil.IsZero() && clock().Before(state.manualUntil) {
+			replicas, until := state.manualReplicas, state.manualUntil
+			status.ManualReplicas = &replicas
+			status.ManualUntil = &until
+		}
+		status.LastActionAt = timeOrNil(state.lastActionAt)
+		status.CoolDownUntil = timeOrNil(state.coolDownUntil)
+		status.InstanceCoolDownUntil = timeOrNil(state.instanceCoolDownUntil)
+		status.IdleSince = timeOrNil(state.idleSince)
+		records := state.decisions.list()
+		if len(records) > 0 {
+			status.LastDecision = &records[len(records)-1]
+		}
+	})
+	return status
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
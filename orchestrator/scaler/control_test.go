@@ -0,0 +1,119 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"Caronte/engine"
+	"Caronte/orchestrator/leader"
+	"Caronte/statestores"
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryStore keeps the saved states in memory
+type memoryStore struct {
+	lock   sync.Mutex
+	states map[string]statestores.ScalingState
+	saves  int
+}
+
+func (m *memoryStore) Load(ctx context.Context, serviceID string, name string) (statestores.ScalingState, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	state, found := m.states[name]
+	return state, found, nil
+}
+
+func (m *memoryStore) Save(ctx context.Context, serviceID string, name string, state statestores.ScalingState) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.states[name] = state
+	m.saves++
+	return nil
+}
+
+func (m *memoryStore) Delete(ctx context.Context, serviceID string, name string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.states, name)
+	return nil
+}
+
+func useMemoryStore(t *testing.T) *memoryStore {
+	store := &memoryStore{states: make(map[string]statestores.ScalingState)}
+	UseStateStore(store)
+	t.Cleanup(func() { UseStateStore(nil) })
+	return store
+}
+
+// leaderSwarm answers the leadership checks
+type leaderSwarm struct {
+	engine.SwarmEngine
+	leader bool
+}
+
+func (l leaderSwarm) NodeID(ctx context.Context) (string, error) { return "node", nil }
+
+func (l leaderSwarm) IsLeader(ctx context.Context, nodeID string) (bool, error) {
+	return l.leader, nil
+}
+
+func TestControlChangesArePersistedAtOnce(t *testing.T) {
+
+	store := useMemoryStore(t)
+	ctx := context.Background()
+	service := core.CaronteService{Id: "1", Name: "persisted"}
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	store.states[service.Name] = statestores.ScalingState{ManualReplicas: 3, ManualUntil: until}
+
+	if err := Pause(ctx, service); err != nil {
+		t.Fatal(err)
+	}
+	saved, _, _ := store.Load(ctx, service.Id, service.Name)
+	if !saved.Paused {
+		t.Error("the pause was not saved")
+	}
+	if saved.ManualReplicas != 3 || !saved.ManualUntil.Equal(until) {
+		t.Errorf("the pause replaced the persisted forced replicas with %d until %s", saved.ManualReplicas, saved.ManualUntil)
+	}
+
+	//A restarted instance restores the pause
+	forgetState(service.Name)
+	restoreState(ctx, service)
+	if !paused(service.Name) {
+		t.Error("the pause was not restored")
+	}
+
+	if err := ClearReplicas(ctx, service); err != nil {
+		t.Fatal(err)
+	}
+	if saved, _, _ := store.Load(ctx, service.Id, service.Name); saved.ManualReplicas != 0 || !saved.ManualUntil.IsZero() {
+		t.Error("the cleared replicas were not saved")
+	}
+	forgetState(service.Name)
+}
+
+func TestControlChangesAreRefusedOnStandby(t *testing.T) {
+
+	store := useMemoryStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	leader.Watch(ctx, leaderSwarm{leader: false}, time.Hour)
+	cancel()
+	defer leader.Watch(context.Background(), leaderSwarm{leader: true}, time.Hour)
+
+	service := core.CaronteService{Id: "1", Name: "standby"}
+	if err := ForceReplicas(context.Background(), service, 3, time.Minute); err != ErrStandby {
+		t.Errorf("got %v on standby, want ErrStandby", err)
+	}
+	if status := Status(service.Name); status.ManualReplicas != nil {
+		t.Error("the standby instance forced the replicas")
+	}
+	if store.saves != 0 {
+		t.Error("the standby instance saved the state")
+	}
+	forgetState(service.Name)
+}
@@ -0,0 +1,129 @@
+package scaler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ActionNone      = "none"
+	ActionScaleUp   = "scaleUp"
+	ActionScaleDown = "scaleDown"
+)
+
+// DecisionHistorySize is the number of decision records kept per service
+var DecisionHistorySize = 100
+
+// DecisionRecord explains what the scaler decided for a service in a tick
+type DecisionRecord struct {
+	Time                  time.Time      `json:"time"`
+	Service               string         `json:"service"`
+	Policy                string         `json:"policy"`
+	Metrics               []MetricRecord `json:"metrics"`
+	Min                   int            `json:"min"`
+	Max                   int            `json:"max"`
+	CurrentReplicas       int            `json:"currentReplicas"`
+	TargetReplicas        int            `json:"targetReplicas"`
+	Instances             int            `json:"instances"`
+	CoolDownUntil         time.Time      `json:"coolDownUntil"`
+	InstanceCoolDownUntil time.Time      `json:"instanceCoolDownUntil"`
+	Action                string         `json:"action"`
+	InstanceAction        string         `json:"instanceAction"`
+	Reason                string         `json:"reason"`
+	DryRun                bool           `json:"dryRun,omitempty"`
+}
+
+// MetricRecord is the value and recommendation of a metric in a decision
+type MetricRecord struct {
+	Name               string   `json:"name"`
+	Value              float64  `json:"value"`
+	Forecast           *float64 `json:"forecast,omitempty"`
+	ScaleUpThreshold   float64  `json:"scaleUpThreshold"`
+	ScaleDownThreshold float64  `json:"scaleDownThreshold"`
+	Target             float64  `json:"target"`
+	Replicas           int      `json:"replicas"`
+	Reason             string   `json:"reason"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// decisionRing keeps the last DecisionHistorySize records of a service
+type decisionRing struct {
+	records []DecisionRecord
+	next    int
+}
+
+func (d *decisionRing) add(record DecisionRecord) {
+	if DecisionHistorySize <= 0 {
+		return
+	}
+	if len(d.records) < DecisionHistorySize {
+		d.records = append(d.records, record)
+		return
+	}
+	d.records[d.next] = record
+	d.next = (d.next + 1) % len(d.records)
+}
+
+// list returns the records from the oldest to the newest
+func (d *decisionRing) list() []DecisionRecord {
+	records := make([]DecisionRecord, 0, len(d.records))
+	records = append(records, d.records[d.next:]...)
+	return append(records, d.records[:d.next]...)
+}
+
+func recordDecision(record DecisionRecord) {
+	withState(record.Service, func(state *serviceState) {
+		state.decisions.add(record)
+	})
+}
+
+// Decisions returns the decision records of a service from the oldest to the newest
+func Decisions(name string) ([]DecisionRecord, bool) {
+	statesLock.Lock()
+	defer statesLock.Unlock()
+
+	state, contains := states[name]
+	if !contains {
+		return nil, false
+	}
+	return state.decisions.list(), true
+}
+
+// LastDecisions returns the newest decision record of every service
+func LastDecisions() map[string]DecisionRecord {
+	statesLock.Lock()
+	defer statesLock.Unlock()
+
+	last := make(map[string]DecisionRecord)
+	for name, state := range states {
+		records := state.decisions.list()
+		if len(records) > 0 {
+			last[name] = records[len(records)-1]
+		}
+	}
+	return last
+}
+
+// DecisionsHandler serves /decisions/ with the last decision of every service and
+// /decisions/<service> with the decision history of a service
+func DecisionsHandler(w http.ResponseWriter, r *http.Request) {
+
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/decisions"), "/")
+
+	var body interface{}
+	if name == "" {
+		body = LastDecisions()
+	} else {
+		records, contains := Decisions(name)
+		if !contains {
+			http.Error(w, "service not found", http.StatusNotFound)
+			return
+		}
+		body = records
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,71 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"Caronte/engine"
+	"Caronte/instances"
+	"Caronte/metrics_publisher"
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// DryRun runs the scaling decisions of every service without scaling services or instances
+var DryRun bool
+
+// dryRunSwarm reads the swarm through the engine but only records the service scaling
+type dryRunSwarm struct {
+	engine.SwarmEngine
+	service string
+}
+
+func (d dryRunSwarm) Scale(ctx context.Context, serviceID string, target int) (bool, error) {
+
+	total, err := d.SwarmEngine.TotalActiveTasks(ctx, serviceID)
+	if err != nil {
+		return false, err
+	}
+	if total == target {
+		return false, nil
+	}
+
+	action := ActionScaleUp
+	if target < total {
+		action = ActionScaleDown
+	}
+	zap.S().Infof("Dry run: would scale service %s from %d to %d replicas", d.service, total, target)
+	metrics_publisher.RecordDryRunAction(d.service, "service", action)
+	metrics_publisher.RecordDryRunReplicas(d.service, target)
+	return true, nil
+}
+
+// dryRunInstances reads the instances through the provider but only records the instances scaling
+type dryRunInstances struct {
+	instances.InstanceManagerProvider
+	service string
+}
+
+func (d dryRunInstances) Scale(ctx context.Context, scaleSpecs instances.ScaleSpecs, direction int) bool {
+
+	action := ActionScaleUp
+	if direction == ScaleDirectionDown {
+		action = ActionScaleDown
+	}
+	zap.S().Infof("Dry run: would %s the %s instances of service %s", action, scaleSpecs.Provider, d.service)
+	metrics_publisher.RecordDryRunAction(d.service, "instances", action)
+	return true
+}
+
+// dryRun swaps the swarm engine and the instance provider of a dry run service with recording no-ops
+func (s ServiceScale) dryRun(service core.CaronteService) (ServiceScale, core.CaronteService, bool) {
+
+	if !DryRun && !service.DryRun {
+		return s, service, false
+	}
+
+	s.SwarmEngine = dryRunSwarm{SwarmEngine: s.SwarmEngine, service: service.Name}
+	if service.InstanceProvider != nil {
+		service.InstanceProvider = dryRunInstances{InstanceManagerProvider: service.InstanceProvider, service: service.Name}
+	}
+	return s, service, true
+}
@@ -0,0 +1,50 @@
+package scaler
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDryRunKeepsItsBookkeepingInMemory(t *testing.T) {
+
+	store := useMemoryStore(t)
+	ctx := context.Background()
+	s := ServiceScale{SwarmEngine: newWorkerSwarm()}
+	metric := &workerMetric{value: 90}
+	service := workerService("pretended", 10, metric)
+	service.ServiceCoolDownDelay = 600
+	service.DryRun = true
+	defer forgetState(service.Name)
+
+	s.Scale(ctx, service)
+	if status := Status(service.Name); status.LastAction != ActionScaleUp || status.CoolDownUntil == nil {
+		t.Fatalf("the dry run did not pretend the scale up, last decision %+v", status.LastDecision)
+	}
+	if saved, found, _ := store.Load(ctx, service.Id, service.Name); found && (saved.LastAction != "" || !saved.CoolDownUntil.IsZero()) {
+		t.Errorf("the dry run saved its pretended action %+v", saved)
+	}
+
+	if err := Pause(ctx, service); err != nil {
+		t.Fatal(err)
+	}
+	saved, _, _ := store.Load(ctx, service.Id, service.Name)
+	if !saved.Paused || saved.LastAction != "" || !saved.CoolDownUntil.IsZero() {
+		t.Errorf("the dry run pause saved %+v", saved)
+	}
+	if err := Resume(ctx, service); err != nil {
+		t.Fatal(err)
+	}
+
+	//The pretended cool down does not hold the first real scale down
+	service.DryRun = false
+	metric.value = 10
+	s.Scale(ctx, service)
+	records, _ := Decisions(service.Name)
+	last := records[len(records)-1]
+	if last.DryRun || last.Action != ActionScaleDown {
+		t.Errorf("the service out of the dry run did not scale down: %s", last.Reason)
+	}
+	if len(records) != 2 {
+		t.Errorf("the dry run decisions were dropped, %d records", len(records))
+	}
+}
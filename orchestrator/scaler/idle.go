@@ -0,0 +1,71 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"Caronte/metricstores"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// idleMode tells whether the service is allowed to go to zero replicas when idle
+func idleMode(service core.CaronteService) bool {
+	return service.Min == 0 && service.IdleAfter > 0
+}
+
+// idleFor records whether the service is idle in this tick and returns for how long it has
+// been idle without interruption
+func idleFor(name string, idle bool, now time.Time) time.Duration {
+
+	var since time.Duration
+	withState(name, func(state *serviceState) {
+		if !idle {
+			state.idleSince = time.Time{}
+			return
+		}
+		if state.idleSince.IsZero() {
+			state.idleSince = now
+		}
+		since = now.Sub(state.idleSince)
+	})
+
+	return since
+}
+
+// wake brings a service at zero replicas back to its activation replicas when the activation
+// metric is over its threshold. Without an activation metric any service metric over its
+// scale down threshold wakes the service
+func (s ServiceScale) wake(ctx context.Context, service core.CaronteService, record *DecisionRecord) {
+
+	metrics := service.Metrics
+	if service.Activation.MetricProvider != nil {
+		metrics = []core.ServiceMetric{service.Activation}
+	}
+
+	for _, metric := range metrics {
+		if metric.MetricProvider == nil {
+			continue
+		}
+
+		value, err := metric.MetricProvider.Query(ctx, metric.MetricSpecs)
+		if errors.Is(err, metricstores.ErrNoData) {
+			continue
+		} else if err != nil {
+			zap.S().Errorf("Service %s metric %s: %s", service.Name, metric.Name, err)
+			continue
+		}
+
+		if value > metric.ScaleDownThreshold {
+			zap.S().Infof("Service %s woken up by metric %s value %g", service.Name, metric.Name, value)
+			record.Reason = fmt.Sprintf("woken up by metric %s value %g", metric.Name, value)
+			idleFor(service.Name, false, clock())
+			s.scale(ctx, service, 0, service.ActivationReplicas, ScaleDirectionUp, record)
+			return
+		}
+	}
+
+	record.Reason = "idle at zero replicas"
+}
@@ -0,0 +1,86 @@
+package scaler
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pidState is the controller memory kept between ticks for a metric. The bias is the replicas
+// the controller started from, kept outside the integral so its limits do not cut them
+type pidState struct {
+	bias      float64
+	integral  float64
+	lastError float64
+	lastTick  time.Time
+}
+
+// PIDPolicy drives the metric to its target with a PID controller whose output, added to the
+// replicas of its first tick, is the desired replicas. The error is value - target, so a
+// metric over its target adds replicas.
+// The controller memory is passed in and out as the policy state
+type PIDPolicy struct {
+}
+
+func (p PIDPolicy) Recommend(input PolicyInput) Decision {
+
+	service, metric, value, total := input.Service, input.Metric, input.Value, input.CurrentReplicas
+
+	if metric.Target <= 0 {
+		return Decision{
+			Replicas: total,
+			Reason:   "a positive target is required by the pid policy",
+			State:    input.State,
+		}
+	}
+
+	pid := service.PID
+	err := value - metric.Target
+
+	controller, contains := input.State.(pidState)
+	if !contains {
+		//Start from the current replicas so the first tick does not jump to the bounds
+		controller = pidState{bias: float64(total), lastError: err}
+	}
+
+	dt := float64(service.ServiceScheduler)
+	if !controller.lastTick.IsZero() {
+		dt = input.Now.Sub(controller.lastTick).Seconds()
+	}
+	if dt <= 0 {
+		dt = 1
+	}
+
+	derivative := (err - controller.lastError) / dt
+	integral := controller.integral + err*dt
+	if pid.IntegralMax > pid.IntegralMin {
+		integral = math.Max(pid.IntegralMin, math.Min(pid.IntegralMax, integral))
+	}
+
+	output := controller.bias + pid.Kp*err + pid.Ki*integral + pid.Kd*derivative
+
+	//Conditional integration: stop accumulating while the output saturates in the same direction
+	saturatedUp := output > float64(service.Max) && err > 0
+	saturatedDown := output < float64(service.Min) && err < 0
+	if !saturatedUp && !saturatedDown {
+		controller.integral = integral
+	}
+	controller.lastError = err
+	controller.lastTick = input.Now
+
+	replicas := int(math.Round(output))
+	if replicas < service.Min {
+		replicas = service.Min
+	} else if replicas > service.Max {
+		replicas = service.Max
+	}
+	zap.S().Debugf("%d - PID metric %s error %g , output %g , desired replicas %d", service.Thread, metric.Name, err, output, replicas)
+
+	return Decision{
+		Replicas: replicas,
+		Reason:   fmt.Sprintf("error %g for target %g, controller output %g", err, metric.Target, output),
+		State:    controller,
+	}
+}
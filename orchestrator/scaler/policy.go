@@ -0,0 +1,149 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// PolicyInput is what a ScalingPolicy decides on: the service configuration, one of its
+// metrics with the value sampled in this tick and the current replicas. State is the memory
+// the policy returned for the metric in the previous tick, nil in the first one
+type PolicyInput struct {
+	Service         core.CaronteService
+	Metric          core.ServiceMetric
+	Value           float64
+	CurrentReplicas int
+	Now             time.Time
+	State           interface{}
+}
+
+// Decision is the replicas a ScalingPolicy asks for and the reason of the decision.
+// Direction is only needed when the policy wants to move the service without changing
+// the replicas, e.g. to re-apply the Min and Max bounds. State is the memory kept by the
+// scaler for the next tick of the metric, nil for stateless policies
+type Decision struct {
+	Replicas  int
+	Direction int
+	Reason    string
+	State     interface{}
+}
+
+// ScalingPolicy decides the desired replicas of a service for a metric value. A policy must
+// not keep state of its own, the memory it needs is passed in and out of Recommend
+type ScalingPolicy interface {
+	Recommend(input PolicyInput) Decision
+}
+
+var policies = map[string]ScalingPolicy{
+	core.ScalePolicyThreshold: ThresholdPolicy{},
+	core.ScalePolicyTarget:    TargetPolicy{},
+	core.ScalePolicyPID:       PIDPolicy{},
+}
+var policiesLock sync.RWMutex
+
+// RegisterPolicy makes a policy available to the services labelled with caronte.scale.policy=<name>
+func RegisterPolicy(name string, policy ScalingPolicy) {
+	policiesLock.Lock()
+	defer policiesLock.Unlock()
+
+	policies[name] = policy
+}
+
+// GetPolicy returns the policy registered with the name
+func GetPolicy(name string) (ScalingPolicy, bool) {
+	policiesLock.RLock()
+	defer policiesLock.RUnlock()
+
+	policy, contains := policies[name]
+	return policy, contains
+}
+
+func policyFor(service core.CaronteService) ScalingPolicy {
+	policy, contains := GetPolicy(service.Policy)
+	if !contains {
+		zap.S().Warnf("Service %s policy %s is not registered, using %s", service.Name, service.Policy, core.ScalePolicyThreshold)
+		return ThresholdPolicy{}
+	}
+	return policy
+}
+
+// ThresholdPolicy moves the service by the step of the tier matching the metric value
+type ThresholdPolicy struct {
+}
+
+func (p ThresholdPolicy) Recommend(input PolicyInput) Decision {
+
+	direction, step := input.Metric.ScaleStep(input.Value)
+	switch direction {
+	case ScaleDirectionUp:
+		return Decision{
+			Replicas:  input.CurrentReplicas + step,
+			Direction: direction,
+			Reason:    fmt.Sprintf("value %g over the scale up threshold, adding %d replicas", input.Value, step),
+		}
+	case ScaleDirectionDown:
+		return Decision{
+			Replicas:  input.CurrentReplicas - step,
+			Direction: direction,
+			Reason:    fmt.Sprintf("value %g under the scale down threshold, removing %d replicas", input.Value, step),
+		}
+	}
+
+	return Decision{
+		Replicas: input.CurrentReplicas,
+		Reason:   fmt.Sprintf("value %g between thresholds", input.Value),
+	}
+}
+
+// TargetTolerance is the relative distance to the target within which the target policy
+// keeps the current replicas, so a small overshoot does not add a replica on every tick
+var TargetTolerance = 0.1
+
+// TargetPolicy asks for current replicas * value / target, clamped to Min and Max. A service
+// without replicas is sized from max(Min, 1) replicas so it can recover
+type TargetPolicy struct {
+}
+
+func (p TargetPolicy) Recommend(input PolicyInput) Decision {
+
+	if input.Metric.Target <= 0 {
+		return Decision{
+			Replicas: input.CurrentReplicas,
+			Reason:   "a positive target is required by the target policy",
+		}
+	}
+
+	ratio := input.Value / input.Metric.Target
+	replicas := input.CurrentReplicas
+	reason := fmt.Sprintf("value %g for target %g", input.Value, input.Metric.Target)
+	if math.Abs(ratio-1) <= TargetTolerance && input.CurrentReplicas > 0 {
+		reason = fmt.Sprintf("value %g within %g%% of target %g", input.Value, TargetTolerance*100, input.Metric.Target)
+	} else {
+		base := input.CurrentReplicas
+		if base < 1 {
+			base = int(math.Max(float64(input.Service.Min), 1))
+		}
+		replicas = int(math.Ceil(float64(base) * ratio))
+	}
+
+	if replicas < input.Service.Min {
+		replicas = input.Service.Min
+		reason += fmt.Sprintf(", raised to min %d", replicas)
+	} else if replicas > input.Service.Max {
+		replicas = input.Service.Max
+		reason += fmt.Sprintf(", lowered to max %d", replicas)
+	}
+
+	decision := Decision{Replicas: replicas, Reason: reason}
+	if replicas > input.CurrentReplicas {
+		decision.Direction = ScaleDirectionUp
+	} else if replicas < input.CurrentReplicas {
+		decision.Direction = ScaleDirectionDown
+	}
+	return decision
+}
@@ -0,0 +1,233 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"testing"
+	"time"
+)
+
+func TestThresholdPolicy(t *testing.T) {
+
+	service := core.CaronteService{Name: "api", Min: 1, Max: 10}
+	thresholds := core.ServiceMetric{Name: core.DefaultMetric, Step: 2, ScaleUpThreshold: 80, ScaleDownThreshold: 20}
+	tiers := core.ServiceMetric{Name: core.DefaultMetric, Steps: []core.StepAdjustment{
+		{Threshold: 100, Adjustment: 1},
+		{Threshold: 500, Adjustment: 4},
+		{Threshold: 50, Adjustment: -1},
+		{Threshold: 10, Adjustment: -3},
+	}}
+
+	tests := []struct {
+		name      string
+		metric    core.ServiceMetric
+		value     float64
+		current   int
+		replicas  int
+		direction int
+	}{
+		{"over the scale up threshold", thresholds, 90, 4, 6, ScaleDirectionUp},
+		{"at the scale up threshold", thresholds, 80, 4, 6, ScaleDirectionUp},
+		{"under the scale down threshold", thresholds, 10, 4, 2, ScaleDirectionDown},
+		{"between thresholds", thresholds, 50, 4, 4, 0},
+		{"lowest scale up tier", tiers, 150, 4, 5, ScaleDirectionUp},
+		{"highest scale up tier", tiers, 800, 4, 8, ScaleDirectionUp},
+		{"least aggressive scale down tier", tiers, 40, 4, 3, ScaleDirectionDown},
+		{"most aggressive scale down tier", tiers, 5, 4, 1, ScaleDirectionDown},
+		{"between tiers", tiers, 75, 4, 4, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := ThresholdPolicy{}.Recommend(PolicyInput{Service: service, Metric: test.metric, Value: test.value, CurrentReplicas: test.current})
+			if decision.Replicas != test.replicas || decision.Direction != test.direction {
+				t.Errorf("got %d replicas direction %d, want %d replicas direction %d (%s)", decision.Replicas, decision.Direction, test.replicas, test.direction, decision.Reason)
+			}
+			if decision.State != nil {
+				t.Errorf("threshold policy returned state %v", decision.State)
+			}
+		})
+	}
+}
+
+func TestTargetPolicy(t *testing.T) {
+
+	metric := core.ServiceMetric{Name: core.DefaultMetric, Target: 100}
+
+	tests := []struct {
+		name      string
+		min       int
+		target    float64
+		value     float64
+		current   int
+		replicas  int
+		direction int
+	}{
+		{"over the target", 1, 100, 150, 4, 6, ScaleDirectionUp},
+		{"under the target", 1, 100, 50, 4, 2, ScaleDirectionDown},
+		{"within the tolerance over the target", 1, 100, 105, 4, 4, 0},
+		{"within the tolerance under the target", 1, 100, 95, 4, 4, 0},
+		{"just out of the tolerance", 1, 100, 111, 4, 5, ScaleDirectionUp},
+		{"clamped to max", 1, 100, 1000, 4, 10, ScaleDirectionUp},
+		{"clamped to min", 2, 100, 1, 4, 2, ScaleDirectionDown},
+		{"raised to min within the tolerance", 3, 100, 100, 1, 3, ScaleDirectionUp},
+		{"lowered to max within the tolerance", 1, 100, 100, 12, 10, ScaleDirectionDown},
+		{"no replicas sized from min", 2, 100, 300, 0, 6, ScaleDirectionUp},
+		{"no replicas sized from one replica", 0, 100, 300, 0, 3, ScaleDirectionUp},
+		{"no replicas at target", 2, 100, 100, 0, 2, ScaleDirectionUp},
+		{"no target", 1, 0, 100, 4, 4, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := core.CaronteService{Name: "api", Min: test.min, Max: 10}
+			metric.Target = test.target
+			decision := TargetPolicy{}.Recommend(PolicyInput{Service: service, Metric: metric, Value: test.value, CurrentReplicas: test.current})
+			if decision.Replicas != test.replicas || decision.Direction != test.direction {
+				t.Errorf("got %d replicas direction %d, want %d replicas direction %d (%s)", decision.Replicas, decision.Direction, test.replicas, test.direction, decision.Reason)
+			}
+		})
+	}
+}
+
+func TestPIDPolicy(t *testing.T) {
+
+	start := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	metric := core.ServiceMetric{Name: core.DefaultMetric, Target: 100}
+	service := core.CaronteService{
+		Name:             "api",
+		Min:              1,
+		Max:              20,
+		ServiceScheduler: 10,
+		PID:              core.PIDSpecs{Kp: 0.01, Ki: 0.001, Kd: 0.1},
+	}
+
+	tests := []struct {
+		name     string
+		service  core.CaronteService
+		state    interface{}
+		value    float64
+		current  int
+		now      time.Time
+		replicas int
+		next     pidState
+	}{
+		{
+			name:    "first tick starts from the current replicas",
+			service: service,
+			value:   100, current: 4, now: start,
+			replicas: 4,
+			next:     pidState{bias: 4, integral: 0, lastError: 0, lastTick: start},
+		},
+		{
+			name:    "first tick over the target integrates over the scheduler interval",
+			service: service,
+			value:   200, current: 4, now: start,
+			// 4 + 0.01*100 + 0.001*100*10 + 0.1*0
+			replicas: 6,
+			next:     pidState{bias: 4, integral: 1000, lastError: 100, lastTick: start},
+		},
+		{
+			name:    "next tick integrates over the elapsed time",
+			service: service,
+			state:   pidState{bias: 4, integral: 1000, lastError: 100, lastTick: start},
+			value:   150, current: 6, now: start.Add(20 * time.Second),
+			// 4 + 0.01*50 + 0.001*(1000+50*20) + 0.1*(50-100)/20
+			replicas: 6,
+			next:     pidState{bias: 4, integral: 2000, lastError: 50, lastTick: start.Add(20 * time.Second)},
+		},
+		{
+			name:    "bias does not follow the current replicas",
+			service: service,
+			state:   pidState{bias: 4, integral: 0, lastError: 0, lastTick: start},
+			value:   100, current: 9, now: start.Add(10 * time.Second),
+			replicas: 4,
+			next:     pidState{bias: 4, integral: 0, lastError: 0, lastTick: start.Add(10 * time.Second)},
+		},
+		{
+			name:    "saturated output stops the integral",
+			service: service,
+			state:   pidState{bias: 4, integral: 15000, lastError: 900, lastTick: start},
+			value:   1000, current: 20, now: start.Add(10 * time.Second),
+			replicas: 20,
+			next:     pidState{bias: 4, integral: 15000, lastError: 900, lastTick: start.Add(10 * time.Second)},
+		},
+		{
+			name: "integral limits",
+			service: func() core.CaronteService {
+				limited := service
+				limited.PID.IntegralMin = 0
+				limited.PID.IntegralMax = 4400
+				return limited
+			}(),
+			state: pidState{bias: 5, integral: 4000, lastError: 100, lastTick: start},
+			value: 200, current: 5, now: start.Add(10 * time.Second),
+			// 5 + 0.01*100 + 0.001*4400
+			replicas: 10,
+			next:     pidState{bias: 5, integral: 4400, lastError: 100, lastTick: start.Add(10 * time.Second)},
+		},
+		{
+			name: "integral limits do not cut the starting replicas",
+			service: func() core.CaronteService {
+				limited := service
+				limited.PID = core.PIDSpecs{Ki: 0.05, IntegralMin: -100, IntegralMax: 100}
+				return limited
+			}(),
+			value: 100, current: 10, now: start,
+			replicas: 10,
+			next:     pidState{bias: 10, integral: 0, lastError: 0, lastTick: start},
+		},
+		{
+			name:    "tick at the same time as the previous one",
+			service: service,
+			state:   pidState{bias: 4, integral: 0, lastError: 0, lastTick: start},
+			value:   100, current: 4, now: start,
+			replicas: 4,
+			next:     pidState{bias: 4, integral: 0, lastError: 0, lastTick: start},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision := PIDPolicy{}.Recommend(PolicyInput{
+				Service:         test.service,
+				Metric:          metric,
+				Value:           test.value,
+				CurrentReplicas: test.current,
+				Now:             test.now,
+				State:           test.state,
+			})
+			if decision.Replicas != test.replicas {
+				t.Errorf("got %d replicas, want %d (%s)", decision.Replicas, test.replicas, decision.Reason)
+			}
+			next, ok := decision.State.(pidState)
+			if !ok {
+				t.Fatalf("got state %v, want a pid state", decision.State)
+			}
+			if next != test.next {
+				t.Errorf("got state %+v, want %+v", next, test.next)
+			}
+		})
+	}
+}
+
+func TestPIDPolicyDoesNotChangeItsInput(t *testing.T) {
+
+	start := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	input := PolicyInput{
+		Service:         core.CaronteService{Name: "api", Min: 1, Max: 20, PID: core.PIDSpecs{Kp: 0.01, Ki: 0.001}},
+		Metric:          core.ServiceMetric{Name: core.DefaultMetric, Target: 100},
+		Value:           200,
+		CurrentReplicas: 4,
+		Now:             start.Add(10 * time.Second),
+		State:           pidState{bias: 4, integral: 0, lastError: 100, lastTick: start},
+	}
+
+	first := PIDPolicy{}.Recommend(input)
+	second := PIDPolicy{}.Recommend(input)
+	if first != second {
+		t.Errorf("same input gave %+v and %+v", first, second)
+	}
+	if input.State != (pidState{bias: 4, integral: 0, lastError: 100, lastTick: start}) {
+		t.Errorf("input state changed to %+v", input.State)
+	}
+}
@@ -0,0 +1,37 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"Caronte/forecast"
+	"Caronte/metrics_publisher"
+
+	"go.uber.org/zap"
+)
+
+// predict records the metric value into its series and, once the forecast is available,
+// pre-scales the service when the forecast value asks for more replicas than the live one.
+// The forecast is evaluated from the policy memory the live value started from and its own
+// memory is dropped, so a stateful policy only advances once per tick
+func (s ServiceScale) predict(service core.CaronteService, metric core.ServiceMetric, value float64, total int, state interface{}, live recommendation) recommendation {
+
+	predicted, ok := forecast.Observe(forecastKey(service, metric), clock(), value, service.Predictive.Specs)
+	if !ok {
+		return live
+	}
+	metrics_publisher.RecordMetricForecast(service.Name, metric.Name, predicted)
+	live.forecast = &predicted
+
+	ahead, _ := recommend(service, metric, predicted, total, state)
+	zap.S().Debugf("%d - Metric %s forecast %g , desired replicas %d", service.Thread, metric.Name, predicted, ahead.replicas)
+	if ahead.replicas > live.replicas {
+		live.direction = ahead.direction
+		live.replicas = ahead.replicas
+		live.reason = "forecast " + ahead.reason
+	}
+
+	return live
+}
+
+func forecastKey(service core.CaronteService, metric core.ServiceMetric) string {
+	return service.Name + "." + metric.Name
+}
@@ -0,0 +1,113 @@
+package scaler
+
+import (
+	"Caronte/core"
+)
+
+// recommendation is the replicas a single metric asks for
+type recommendation struct {
+	metric    string
+	value     float64
+	direction int
+	replicas  int
+	reason    string
+	forecast  *float64
+	idle      bool
+}
+
+// recommend calculates the replicas wanted by a metric value with the service policy, from
+// the policy memory of the previous tick. The memory for the next tick is returned, so the
+// same tick can be evaluated for another value without changing it
+func recommend(service core.CaronteService, metric core.ServiceMetric, value float64, total int, state interface{}) (recommendation, interface{}) {
+
+	decision := policyFor(service).Recommend(PolicyInput{
+		Service:         service,
+		Metric:          metric,
+		Value:           value,
+		CurrentReplicas: total,
+		Now:             clock(),
+		State:           state,
+	})
+
+	result := recommendation{
+		metric:    metric.Name,
+		value:     value,
+		direction: decision.Direction,
+		replicas:  decision.Replicas,
+		reason:    decision.Reason,
+		idle:      value <= metric.ScaleDownThreshold,
+	}
+	if result.direction == 0 {
+		if result.replicas > total {
+			result.direction = ScaleDirectionUp
+		} else if result.replicas < total {
+			result.direction = ScaleDirectionDown
+		}
+	}
+
+	return result, decision.State
+}
+
+// policyState returns the policy memory kept for a metric of the service
+func policyState(name string, metric string) interface{} {
+	var state interface{}
+	withState(name, func(serviceState *serviceState) {
+		state = serviceState.policies[metric]
+	})
+	return state
+}
+
+// keepPolicyState keeps the policy memory of a metric for the next tick, nil drops it
+func keepPolicyState(name string, metric string, state interface{}) {
+	withState(name, func(serviceState *serviceState) {
+		if state == nil {
+			delete(serviceState.policies, metric)
+			return
+		}
+		if serviceState.policies == nil {
+			serviceState.policies = make(map[string]interface{})
+		}
+		serviceState.policies[metric] = state
+	})
+}
+
+// combine merges the recommendations of every metric. The max mode takes the recommendation
+// asking for the most replicas. The vote mode scales up when any metric asks for it and scales
+// down only when all the metrics agree, using the least aggressive scale down
+func combine(mode string, recommendations []recommendation, metrics int) (recommendation, bool) {
+
+	if len(recommendations) == 0 {
+		return recommendation{}, false
+	}
+
+	if mode == core.MetricCombineVote {
+		var ups, downs []recommendation
+		for _, r := range recommendations {
+			if r.direction == ScaleDirectionUp {
+				ups = append(ups, r)
+			} else if r.direction == ScaleDirectionDown {
+				downs = append(downs, r)
+			}
+		}
+		if len(ups) > 0 {
+			return highest(ups), true
+		}
+		if len(downs) == metrics {
+			return highest(downs), true
+		}
+		return recommendation{}, false
+	}
+
+	result := highest(recommendations)
+	return result, result.direction != 0
+}
+
+func highest(recommendations []recommendation) recommendation {
+	result := recommendations[0]
+	for _, r := range recommendations[1:] {
+		if r.replicas > result.replicas || (r.replicas == result.replicas && r.direction > result.direction) {
+			result = r
+		}
+	}
+	return result
+}
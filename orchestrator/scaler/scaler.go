@@ -3,6 +3,13 @@ package scaler
 import (
 	"Caronte/core"
 	"Caronte/engine"
+	"Caronte/forecast"
+	"Caronte/metrics_publisher"
+	"Caronte/metricstores"
+	"Caronte/orchestrator/leader"
+	"context"
+	"errors"
+	"fmt"
 	"math/rand"
 	"time"
 
@@ -18,10 +25,29 @@ type ServiceScale struct {
 	SwarmEngine engine.SwarmEngine
 }
 
-var renew = make(chan string)
-var activeServices = make(map[string]core.CaronteService)
+// defaultScaleInterval is the time between ticks of a service without caronte.ervice.scheduler.scale.time
+const defaultScaleInterval = 10 * time.Second
+
 var r1 = rand.New(rand.NewSource(time.Now().UnixNano()))
 
+// clock is the time seen by the scaler, replaced by the simulation
+var clock = time.Now
+
+// SetClock replaces the time seen by the scaler, nil restores the wall clock
+func SetClock(now func() time.Time) {
+	if now == nil {
+		now = time.Now
+	}
+	clock = now
+}
+
+// serviceWorker is the single goroutine scaling a service. It is cancelled when the service
+// is unsubscribed or its configuration changes, the scaling action in flight is let finish
+type serviceWorker struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
 func NewServiceScale() (ServiceScale, error) {
 
 	swarmEngine, err := engine.NewSwarm()
@@ -31,145 +57,414 @@ func NewServiceScale() (ServiceScale, error) {
 	}, err
 }
 
-func (s ServiceScale) Init(service chan core.CaronteService, unsuscribe chan core.CaronteService) {
+// Init starts the loop that owns the service workers. A subscribed service replaces the
+// worker of a previous configuration, a subscribed service with errors only stops it, keeping
+// its scaling state, and an empty service stops every worker. Once the context is cancelled
+// every worker is stopped and Stopped is closed
+func (s ServiceScale) Init(ctx context.Context, service chan core.CaronteService, unsuscribe chan core.CaronteService) {
 
+	stopped := newStopped()
 	go func() {
+		workers := make(map[string]*serviceWorker)
 		for {
 			select {
-			case name := <-renew:
-				_, contains := activeServices[name]
-				if contains {
-					go s.worker(activeServices[name], renew)
+			case <-ctx.Done():
+				for name, worker := range workers {
+					worker.stop()
+					delete(workers, name)
 				}
+				close(stopped)
+				return
 
 			case service := <-service:
 
-				if (service == core.CaronteService{}) {
-					activeServices = make(map[string]core.CaronteService)
+				if service.Name == "" {
+					for name, worker := range workers {
+						worker.stop()
+						delete(workers, name)
+					}
 
 				} else {
+					if worker, contains := workers[service.Name]; contains {
+						worker.stop()
+						delete(workers, service.Name)
+					}
+					if len(service.Errors) > 0 {
+						zap.S().Infof("Service %s not scaled until its labels are fixed", service.Name)
+						continue
+					}
 					service.Thread = r1.Intn(1000)
-					service.InstanceSpecs.UpdatedAt = activeServices[service.Name].InstanceSpecs.UpdatedAt
 					zap.S().Infof("Service %s subscribed", service.Name)
-					activeServices[service.Name] = service
-					go s.worker(service, renew)
+					workers[service.Name] = s.start(ctx, service)
 				}
 
 			case unsuscribe := <-unsuscribe:
 				zap.S().Infof("Service %s unsuscribed", unsuscribe.Name)
-				delete(activeServices, unsuscribe.Name)
+				if worker, contains := workers[unsuscribe.Name]; contains {
+					worker.stop()
+					delete(workers, unsuscribe.Name)
+				}
+				forgetState(unsuscribe.Name)
+				deleteState(ctx, unsuscribe)
+				for _, metric := range unsuscribe.Metrics {
+					forecast.Forget(forecastKey(unsuscribe, metric))
+					metrics_publisher.ForgetMetric(unsuscribe.Name, metric.Name)
+				}
+				metrics_publisher.ForgetDryRun(unsuscribe.Name)
 			}
 		}
 	}()
 
 }
 
-func (s ServiceScale) worker(service core.CaronteService, renew chan<- string) {
+// start runs the worker of the service until it is stopped or the context is cancelled
+func (s ServiceScale) start(ctx context.Context, service core.CaronteService) *serviceWorker {
 
-	result, err := service.MetricProvider.Query(service.MetricSpecs)
-	if err != nil {
-		zap.S().Error(err)
-	} else {
+	ctx, cancel := context.WithCancel(ctx)
+	worker := &serviceWorker{
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	interval := time.Duration(service.ServiceScheduler) * time.Second
+	if interval <= 0 {
+		interval = defaultScaleInterval
+	}
 
-		if result >= service.ScaleUpThreshold {
-			s.Scale(service, ScaleDirectionUp)
-		} else if result <= service.ScaleDownThreshold {
-			s.Scale(service, ScaleDirectionDown)
+	go func() {
+		defer close(worker.done)
+		for {
+			s.tick(ctx, service)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
 		}
+	}()
 
-	}
-	time.Sleep(time.Duration(service.ServiceScheduler) * time.Second)
+	return worker
+}
 
-	renew <- service.Name
+// stop cancels the worker and waits for the tick in progress to finish
+func (w *serviceWorker) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// tick scales the service once, a panic is logged and the worker keeps running
+func (s ServiceScale) tick(ctx context.Context, service core.CaronteService) {
+	defer func() {
+		if r := recover(); r != nil {
+			zap.S().Errorf("Service %s scaling failed: %v", service.Name, r)
+		}
+	}()
 
+	s.Scale(ctx, service)
 }
 
-func (s ServiceScale) Scale(service core.CaronteService, direction int) {
+// Scale queries every metric of the service, combines their recommendations with the
+// service combine mode and moves the service to the resulting replicas. While a schedule
+// is active the service is kept within the scheduled Min and Max. Forced replicas take over
+// the metrics until they expire and a paused service is left as it is. Every call leaves a
+// decision record explaining the action taken or why it was skipped
+func (s ServiceScale) Scale(ctx context.Context, service core.CaronteService) {
+
+	s, service, dryRun := s.dryRun(service)
+	dryRunState(service.Name, dryRun)
+	record := DecisionRecord{
+		Time:    clock(),
+		Service: service.Name,
+		Policy:  service.Policy,
+		Action:  ActionNone,
+		DryRun:  dryRun,
+	}
+	defer func() {
+		recordDecision(record)
+		persistState(ctx, service)
+	}()
+
+	if isDraining() {
+		record.Reason = "Caronte is shutting down"
+		return
+	}
 
-	total, err := s.SwarmEngine.TotalActiveTasks(service.Id)
+	if !leader.IsLeader() {
+		standbyState(service)
+		record.Reason = "standby, Caronte is not running on the swarm leader"
+		return
+	}
+	restoreState(ctx, service)
+
+	total, err := s.SwarmEngine.TotalActiveTasks(ctx, service.Id)
 	if err != nil {
 		zap.S().Error(err)
+		record.Reason = err.Error()
+		return
+	}
+	record.CurrentReplicas = total
+	record.TargetReplicas = total
+
+	if replicas, forced := manualReplicas(service.Name, clock()); forced {
+		record.TargetReplicas = replicas
+		record.Reason = fmt.Sprintf("replicas forced to %d", replicas)
+		s.apply(ctx, service, total, replicas, &record)
+		return
+	}
+
+	if paused(service.Name) {
+		record.Reason = "autoscaling paused"
+		return
+	}
+
+	service, scheduled := withSchedule(service, clock())
+	record.Min = service.Min
+	record.Max = service.Max
+	if scheduled && (total < service.Min || total > service.Max) {
+		record.Reason = "keeping the replicas within the active schedule"
+		if total < service.Min {
+			s.scale(ctx, service, total, service.Min, ScaleDirectionUp, &record)
+		} else {
+			s.scale(ctx, service, total, service.Max, ScaleDirectionDown, &record)
+		}
+		return
+	}
+
+	if idleMode(service) && total == 0 {
+		s.wake(ctx, service, &record)
+		return
+	}
+
+	var recommendations []recommendation
+	failed := ""
+	for _, metric := range service.Metrics {
+		metricRecord := MetricRecord{
+			Name:               metric.Name,
+			ScaleUpThreshold:   metric.ScaleUpThreshold,
+			ScaleDownThreshold: metric.ScaleDownThreshold,
+			Target:             metric.Target,
+		}
+
+		if metric.MetricProvider == nil {
+			zap.S().Errorf("Service %s metric %s has not a valid store", service.Name, metric.Name)
+			metricRecord.Error = "invalid metric store"
+			record.Metrics = append(record.Metrics, metricRecord)
+			failed = metric.Name
+			continue
+		}
+
+		value, err := metric.MetricProvider.Query(ctx, metric.MetricSpecs)
+		if err != nil {
+			if errors.Is(err, metricstores.ErrNoData) {
+				zap.S().Debugf("%d - Metric %s has no data", service.Thread, metric.Name)
+			} else {
+				zap.S().Errorf("Service %s metric %s: %s", service.Name, metric.Name, err)
+				failed = metric.Name
+			}
+			metricRecord.Error = err.Error()
+			record.Metrics = append(record.Metrics, metricRecord)
+			continue
+		}
+
+		metrics_publisher.RecordMetricValue(service.Name, metric.Name, value)
+		state := policyState(service.Name, metric.Name)
+		recommendation, next := recommend(service, metric, value, total, state)
+		if service.Predictive.Enable && service.Predictive.Metric == metric.Name {
+			recommendation = s.predict(service, metric, value, total, state, recommendation)
+		}
+		keepPolicyState(service.Name, metric.Name, next)
+		zap.S().Debugf("%d - Metric %s value %g , direction %d , desired replicas %d: %s", service.Thread, metric.Name, value, recommendation.direction, recommendation.replicas, recommendation.reason)
+		recommendations = append(recommendations, recommendation)
+
+		metricRecord.Value = value
+		metricRecord.Forecast = recommendation.forecast
+		metricRecord.Replicas = recommendation.replicas
+		metricRecord.Reason = recommendation.reason
+		record.Metrics = append(record.Metrics, metricRecord)
+	}
+
+	if len(recommendations) == 0 {
+		record.Reason = "no metric value available"
+		return
+	}
+
+	if idleMode(service) {
+		idle := len(recommendations) == len(service.Metrics)
+		for _, r := range recommendations {
+			idle = idle && r.idle
+		}
+		if idleFor(service.Name, idle, clock()) >= time.Duration(service.IdleAfter)*time.Second {
+			zap.S().Infof("Service %s idle for %d seconds, scaling to zero", service.Name, service.IdleAfter)
+			record.Reason = fmt.Sprintf("idle for %d seconds", service.IdleAfter)
+			s.scale(ctx, service, total, 0, ScaleDirectionDown, &record)
+			return
+		}
+		//Zero replicas are only reached through the idle mode
+		service.Min = 1
+		record.Min = 1
+	}
+
+	result, ok := combine(service.MetricCombine, recommendations, len(service.Metrics))
+	desired := total
+	if ok {
+		desired = result.replicas
+	}
+
+	//The failed metric may be the one holding the replicas, only a scale up is safe
+	if ok && result.direction == ScaleDirectionDown && failed != "" {
+		zap.S().Debugf("%d - Scale down to %d replicas skipped, metric %s failed", service.Thread, result.replicas, failed)
+		record.Reason = fmt.Sprintf("scale down to %d replicas skipped, metric %s failed", result.replicas, failed)
+		return
+	}
+
+	stabilized := stabilize(service, desired, clock())
+	if ok && result.direction == ScaleDirectionDown {
+		if stabilized >= total {
+			zap.S().Debugf("%d - Scale down to %d replicas held by the stabilization window", service.Thread, desired)
+			record.Reason = fmt.Sprintf("scale down to %d replicas held by the stabilization window", desired)
+			return
+		}
+		result.replicas = stabilized
+	}
+
+	if !ok {
+		record.Reason = "metrics do not ask to scale"
+		return
 	}
 
-	targetReplicas := total + (service.Step * direction)
+	record.Reason = fmt.Sprintf("metric %s: %s", result.metric, result.reason)
+	s.scale(ctx, service, total, result.replicas, result.direction, &record)
+}
+
+// scale moves the service to the target replicas clamped to Min and Max. With an instance
+// provider the instances are scaled first and the replicas follow once the capacity is there
+func (s ServiceScale) scale(ctx context.Context, service core.CaronteService, total int, targetReplicas int, direction int, record *DecisionRecord) {
+
 	if targetReplicas < service.Min {
 		targetReplicas = service.Min
 	} else if targetReplicas > service.Max {
 		targetReplicas = service.Max
 	}
+	record.TargetReplicas = targetReplicas
+
+	coolDown, instanceCoolDown := coolDowns(service.Name)
+	record.CoolDownUntil = coolDown
+	record.InstanceCoolDownUntil = instanceCoolDown
 
 	if service.InstanceSpecs.Provider != "" {
-		instances := service.InstanceProvider.RunningInstances(service.InstanceSpecs)
+		instances := service.InstanceProvider.RunningInstances(ctx, service.InstanceSpecs)
+		record.Instances = instances
 		zap.S().Debugf("%d - TargetReplicas %d , Instances %d, active %d ", service.Thread, targetReplicas, instances, total)
 		if (targetReplicas / service.MaxReplicasPerNode) != instances {
 			if direction == ScaleDirectionUp {
-				pending, _ := s.SwarmEngine.PendingTasks(service.Id)
+				pending, _ := s.SwarmEngine.PendingTasks(ctx, service.Id)
 				//Run Infrastructure scale up only when there are not pending tasks
 				if pending == 0 {
-					ready := service.InstanceProvider.Scale(service.InstanceSpecs, ScaleDirectionUp)
-
-					if ready {
-						service.InstanceSpecs.UpdatedAt = time.Now().Add(time.Duration(service.InstanceSpecs.CoolDown) * time.Second)
-						//Override service to store the UpadtedAt value
-						activeServices[service.Name] = service
-						zap.S().Debugf("%d - Instance UpdatedAt ", service.Thread, service.InstanceSpecs.UpdatedAt)
-						_, err := s.SwarmEngine.Scale(service.Name, targetReplicas)
-						if err != nil {
-							zap.S().Error(err)
-						}
+					ready := false
+					if !inFlight(ctx, func(ctx context.Context) {
+						ready = service.InstanceProvider.Scale(ctx, service.InstanceSpecs, ScaleDirectionUp)
+					}) {
+						record.Reason = "Caronte is shutting down"
+					} else if ready {
+						record.InstanceAction = ActionScaleUp
+						record.InstanceCoolDownUntil = clock().Add(time.Duration(service.InstanceSpecs.CoolDown) * time.Second)
+						withState(service.Name, func(state *serviceState) {
+							state.instanceCoolDownUntil = record.InstanceCoolDownUntil
+						})
+						zap.S().Debugf("%d - Instance cool down until %s", service.Thread, record.InstanceCoolDownUntil)
+						s.apply(ctx, service, total, targetReplicas, record)
+					} else {
+						record.Reason = "instance provider can not scale up"
 					}
+				} else {
+					record.Reason = fmt.Sprintf("waiting for %d pending tasks before adding instances", pending)
 				}
 
 			} else if direction == ScaleDirectionDown {
 
-				if time.Now().After(service.InstanceSpecs.UpdatedAt) {
-					pending, _ := s.SwarmEngine.PendingTasks(service.Id)
+				if clock().After(instanceCoolDown) {
+					pending, _ := s.SwarmEngine.PendingTasks(ctx, service.Id)
 					//Run Infrastructure scale down only when there are not pending tasks
 					if pending == 0 {
-						service.InstanceProvider.Scale(service.InstanceSpecs, ScaleDirectionDown)
+						inFlight(ctx, func(ctx context.Context) {
+							if service.InstanceProvider.Scale(ctx, service.InstanceSpecs, ScaleDirectionDown) {
+								record.InstanceAction = ActionScaleDown
+							}
+						})
 					}
 
 					if instances*service.MaxReplicasPerNode == targetReplicas {
-
-						_, err := s.SwarmEngine.Scale(service.Name, targetReplicas)
-						if err != nil {
-							zap.S().Error(err)
-						}
+						s.apply(ctx, service, total, targetReplicas, record)
+					} else {
+						record.Reason = fmt.Sprintf("waiting for %d instances to hold %d replicas", targetReplicas/service.MaxReplicasPerNode, targetReplicas)
 					}
 
+				} else {
+					record.Reason = "instance cool down"
 				}
 
 			}
 		} else if direction == ScaleDirectionDown {
-			if time.Now().After(service.InstanceSpecs.UpdatedAt) {
+			if clock().After(instanceCoolDown) {
 				if instances*service.MaxReplicasPerNode == targetReplicas {
-					_, err := s.SwarmEngine.Scale(service.Name, targetReplicas)
-					if err != nil {
-						zap.S().Error(err)
-					}
+					s.apply(ctx, service, total, targetReplicas, record)
+				} else {
+					record.Reason = fmt.Sprintf("instances do not match %d replicas", targetReplicas)
 				}
+			} else {
+				record.Reason = "instance cool down"
 			}
+		} else {
+			record.Reason = fmt.Sprintf("instances already match %d replicas", targetReplicas)
 		}
 	} else {
 
 		zap.S().Debugf("%d - TargetReplicas %d ,  active %d ", service.Thread, targetReplicas, total)
 		if direction == ScaleDirectionUp && targetReplicas <= service.Max {
-			_, err := s.SwarmEngine.Scale(service.Name, targetReplicas)
-			if err != nil {
-				zap.S().Error(err)
-			}
-			service.UpdatedAt = time.Now().Add(time.Duration(service.ServiceCoolDownDelay) * time.Second)
-			activeServices[service.Name] = service
-			zap.S().Debugf("%d - Service UpdatedAt ", service.Thread, service.UpdatedAt)
+			s.apply(ctx, service, total, targetReplicas, record)
+			record.CoolDownUntil = clock().Add(time.Duration(service.ServiceCoolDownDelay) * time.Second)
+			withState(service.Name, func(state *serviceState) {
+				state.coolDownUntil = record.CoolDownUntil
+			})
+			zap.S().Debugf("%d - Service cool down until %s", service.Thread, record.CoolDownUntil)
 		}
 		if direction == ScaleDirectionDown && targetReplicas >= service.Min {
-			if time.Now().After(service.UpdatedAt) {
-				_, err := s.SwarmEngine.Scale(service.Name, targetReplicas)
-				if err != nil {
-					zap.S().Error(err)
-				}
-
+			if clock().After(coolDown) {
+				s.apply(ctx, service, total, targetReplicas, record)
+			} else {
+				record.Reason = "service cool down"
 			}
 		}
 	}
 }
+
+// apply scales the swarm service and records the action
+func (s ServiceScale) apply(ctx context.Context, service core.CaronteService, total int, targetReplicas int, record *DecisionRecord) {
+
+	var scaled bool
+	var err error
+	if !inFlight(ctx, func(ctx context.Context) { scaled, err = s.SwarmEngine.Scale(ctx, service.Name, targetReplicas) }) {
+		record.Reason = "Caronte is shutting down"
+		return
+	}
+	if err != nil {
+		zap.S().Error(err)
+		record.Reason = err.Error()
+		return
+	}
+
+	if !scaled {
+		record.Reason = fmt.Sprintf("service already at %d replicas", targetReplicas)
+		return
+	} else if targetReplicas > total {
+		record.Action = ActionScaleUp
+	} else {
+		record.Action = ActionScaleDown
+	}
+
+	withState(service.Name, func(state *serviceState) {
+		state.lastAction = record.Action
+		state.lastActionAt = clock()
+	})
+}
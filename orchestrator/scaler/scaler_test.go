@@ -0,0 +1,59 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"Caronte/metricstores"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// failingMetric answers every query with its error
+type failingMetric struct {
+	err error
+}
+
+func (f failingMetric) Query(ctx context.Context, specs metricstores.MetricSpecs) (float64, error) {
+	return 0, f.err
+}
+
+func TestFailedMetricBlocksScaleDown(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		other  metricstores.MetricProvider
+		value  float64
+		action string
+		reason string
+	}{
+		{"failed metric blocks the scale down", failingMetric{errors.New("timeout")}, 10, ActionNone, "scale down to 1 replicas skipped, metric failing failed"},
+		{"invalid store blocks the scale down", nil, 10, ActionNone, "scale down to 1 replicas skipped, metric failing failed"},
+		{"failed metric lets scale up", failingMetric{errors.New("timeout")}, 90, ActionScaleUp, ""},
+		{"metric without data lets scale down", failingMetric{metricstores.ErrNoData}, 10, ActionScaleDown, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := workerService("combined", 10, &workerMetric{value: test.value})
+			service.Metrics = append(service.Metrics, core.ServiceMetric{
+				Name:               "failing",
+				Step:               1,
+				ScaleUpThreshold:   80,
+				ScaleDownThreshold: 20,
+				MetricProvider:     test.other,
+			})
+			defer forgetState(service.Name)
+
+			ServiceScale{SwarmEngine: newWorkerSwarm()}.Scale(context.Background(), service)
+			records, _ := Decisions(service.Name)
+			last := records[len(records)-1]
+			if last.Action != test.action {
+				t.Errorf("got action %q, want %q (%s)", last.Action, test.action, last.Reason)
+			}
+			if test.reason != "" && !strings.HasPrefix(last.Reason, test.reason) {
+				t.Errorf("got reason %q, want %q", last.Reason, test.reason)
+			}
+		})
+	}
+}
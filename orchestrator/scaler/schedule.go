@@ -0,0 +1,36 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// withSchedule overrides the service Min and Max with the schedule active at the given time.
+// Schedule values set to 0 keep the service ones. A scheduled Min over the service Max, or a
+// scheduled Max under the service Min, widens the other bound so the schedule is reached
+func withSchedule(service core.CaronteService, now time.Time) (core.CaronteService, bool) {
+
+	schedule, active := service.ActiveSchedule(now)
+	if !active {
+		return service, false
+	}
+
+	if schedule.Min > 0 {
+		service.Min = schedule.Min
+	}
+	if schedule.Max > 0 {
+		service.Max = schedule.Max
+	}
+	if service.Min > service.Max {
+		if schedule.Min > 0 {
+			service.Max = service.Min
+		} else {
+			service.Min = service.Max
+		}
+	}
+	zap.S().Debugf("%d - Schedule %s active, Min %d , Max %d", service.Thread, schedule.Cron, service.Min, service.Max)
+
+	return service, true
+}
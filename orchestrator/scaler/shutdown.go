@@ -0,0 +1,100 @@
+package scaler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// draining is set once the shutdown starts, no scaling action is started afterwards
+var draining bool
+var drainingLock sync.Mutex
+
+// actions tracks the swarm and instance provider scaling calls in flight
+var actions sync.WaitGroup
+
+// stopped is closed once every service worker has returned after the Init context is cancelled
+var stopped = make(chan struct{})
+var stoppedLock sync.Mutex
+
+// Drain stops new scaling actions and waits for the ones in flight until the context is done
+func Drain(ctx context.Context) error {
+
+	drainingLock.Lock()
+	draining = true
+	drainingLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		actions.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stopped is closed once the service workers started by the last Init have returned
+func Stopped() <-chan struct{} {
+	stoppedLock.Lock()
+	defer stoppedLock.Unlock()
+
+	return stopped
+}
+
+// newStopped replaces the channel closed once the service workers have returned
+func newStopped() chan struct{} {
+	stoppedLock.Lock()
+	defer stoppedLock.Unlock()
+
+	stopped = make(chan struct{})
+	return stopped
+}
+
+// isDraining reports whether the shutdown has started
+func isDraining() bool {
+	drainingLock.Lock()
+	defer drainingLock.Unlock()
+
+	return draining
+}
+
+// inFlight runs the scaling action unless the shutdown has started, in which case it
+// returns false. Drain waits for the actions started here. The action runs on a context
+// detached from the worker one, so stopping a relabelled or removed service worker does not
+// abort an action halfway, the engine and instance provider timeouts bound it
+func inFlight(ctx context.Context, action func(ctx context.Context)) bool {
+
+	drainingLock.Lock()
+	if draining {
+		drainingLock.Unlock()
+		return false
+	}
+	actions.Add(1)
+	drainingLock.Unlock()
+
+	defer actions.Done()
+	action(detached{ctx})
+	return true
+}
+
+// detached keeps the values of a context without its cancellation
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"time"
+)
+
+// timedReplicas is a desired replicas recommendation made at a given time
+type timedReplicas struct {
+	at       time.Time
+	replicas int
+}
+
+// stabilize records the desired replicas of the service and returns the highest replicas
+// recommended within the scale down stabilization window, so a scale down only goes as low
+// as every recommendation seen in the window allows
+func stabilize(service core.CaronteService, replicas int, now time.Time) int {
+
+	if service.ScaleDownStabilizationWindow <= 0 {
+		return replicas
+	}
+
+	stabilized := replicas
+	withState(service.Name, func(state *serviceState) {
+		since := now.Add(-time.Duration(service.ScaleDownStabilizationWindow) * time.Second)
+		history := []timedReplicas{{at: now, replicas: replicas}}
+		for _, recommendation := range state.recommendations {
+			if recommendation.at.After(since) {
+				history = append(history, recommendation)
+				if recommendation.replicas > stabilized {
+					stabilized = recommendation.replicas
+				}
+			}
+		}
+		state.recommendations = history
+	})
+
+	return stabilized
+}
@@ -0,0 +1,258 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"Caronte/statestores"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// serviceState is the scaler bookkeeping kept between ticks of a service
+type serviceState struct {
+	recommendations       []timedReplicas
+	idleSince             time.Time
+	policies              map[string]interface{}
+	coolDownUntil         time.Time
+	instanceCoolDownUntil time.Time
+	lastAction            string
+	lastActionAt          time.Time
+	decisions             decisionRing
+	paused                bool
+	manualReplicas        int
+	manualUntil           time.Time
+	persisted             string
+	restored              bool
+	dryRun                bool
+}
+
+var states = make(map[string]*serviceState)
+var statesLock sync.Mutex
+
+// persistLock orders the saves of the ticks and of the control changes
+var persistLock sync.Mutex
+
+// stateStore persists the service states, nil keeps them only in memory
+var stateStore statestores.StateStore
+
+// UseStateStore persists the scaling state of the services into the store and reloads it
+// when a service is subscribed
+func UseStateStore(store statestores.StateStore) {
+	stateStore = store
+}
+
+// withState runs fn holding the lock over the state of the service, creating it when needed
+func withState(name string, fn func(state *serviceState)) {
+	statesLock.Lock()
+	defer statesLock.Unlock()
+
+	state, contains := states[name]
+	if !contains {
+		state = &serviceState{}
+		states[name] = state
+	}
+	fn(state)
+}
+
+// coolDowns returns the service and instance cool down deadlines of the service
+func coolDowns(name string) (time.Time, time.Time) {
+	var coolDown, instanceCoolDown time.Time
+	withState(name, func(state *serviceState) {
+		coolDown = state.coolDownUntil
+		instanceCoolDown = state.instanceCoolDownUntil
+	})
+	return coolDown, instanceCoolDown
+}
+
+// forgetState drops the state of an unsubscribed service
+func forgetState(name string) {
+	statesLock.Lock()
+	defer statesLock.Unlock()
+
+	delete(states, name)
+}
+
+// restoreState loads the persisted state of a service the first time it is scaled by this
+// Caronte instance, replacing the state kept in memory
+func restoreState(ctx context.Context, service core.CaronteService) error {
+
+	if stateStore == nil {
+		return nil
+	}
+
+	restored := false
+	withState(service.Name, func(state *serviceState) {
+		restored = state.restored
+	})
+	if restored {
+		return nil
+	}
+
+	persisted, found, err := stateStore.Load(ctx, service.Id, service.Name)
+	if err != nil {
+		zap.S().Errorf("Fail loading service %s state: %s", service.Name, err)
+		return err
+	}
+
+	withState(service.Name, func(state *serviceState) {
+		//A tick or a control change may have restored it meanwhile
+		if state.restored {
+			found = false
+			return
+		}
+		state.restored = true
+		if !found {
+			return
+		}
+		state.lastAction = persisted.LastAction
+		state.lastActionAt = persisted.LastActionAt
+		state.coolDownUntil = persisted.CoolDownUntil
+		state.instanceCoolDownUntil = persisted.InstanceCoolDownUntil
+		state.idleSince = persisted.IdleSince
+		state.paused = persisted.Paused
+		state.manualReplicas = persisted.ManualReplicas
+		state.manualUntil = persisted.ManualUntil
+		state.recommendations = nil
+		for _, recommendation := range persisted.Recommendations {
+			state.recommendations = append(state.recommendations, timedReplicas{at: recommendation.At, replicas: recommendation.Replicas})
+		}
+		state.policies = nil
+		if len(persisted.PID) > 0 {
+			state.policies = make(map[string]interface{})
+			for name, controller := range persisted.PID {
+				state.policies[name] = pidState{bias: controller.Bias, integral: controller.Integral, lastError: controller.LastError, lastTick: controller.LastTick}
+			}
+		}
+		state.persisted = snapshot(state)
+	})
+	if found {
+		zap.S().Infof("Service %s state restored, last action %s at %s", service.Name, persisted.LastAction, persisted.LastActionAt)
+	}
+	return nil
+}
+
+// standbyState marks the state to be reloaded from the store once this instance leads again,
+// as the leading instance keeps updating it
+func standbyState(service core.CaronteService) {
+	withState(service.Name, func(state *serviceState) {
+		state.restored = false
+	})
+}
+
+// persistState saves the state of the service when it has changed since the last save
+func persistState(ctx context.Context, service core.CaronteService) error {
+
+	if stateStore == nil {
+		return nil
+	}
+
+	persistLock.Lock()
+	defer persistLock.Unlock()
+
+	var current string
+	withState(service.Name, func(state *serviceState) {
+		current = snapshot(state)
+		if state.dryRun {
+			current = controlSnapshot(state)
+		}
+		if !state.restored || current == state.persisted {
+			current = ""
+		}
+	})
+	if current == "" {
+		return nil
+	}
+
+	var persisted statestores.ScalingState
+	json.Unmarshal([]byte(current), &persisted)
+	err := stateStore.Save(ctx, service.Id, service.Name, persisted)
+	if err != nil {
+		zap.S().Errorf("Fail saving service %s state: %s", service.Name, err)
+		return err
+	}
+
+	withState(service.Name, func(state *serviceState) {
+		state.persisted = current
+	})
+	return nil
+}
+
+// dryRunState marks whether the service runs in dry run. A service leaving the dry run drops
+// the bookkeeping of the actions it only pretended, so their cool downs do not hold the real
+// scaling, and reloads the state it saved before
+func dryRunState(name string, dryRun bool) {
+	withState(name, func(state *serviceState) {
+		if state.dryRun && !dryRun {
+			state.recommendations = nil
+			state.idleSince = time.Time{}
+			state.policies = nil
+			state.coolDownUntil = time.Time{}
+			state.instanceCoolDownUntil = time.Time{}
+			state.lastAction = ""
+			state.lastActionAt = time.Time{}
+			state.restored = false
+		}
+		state.dryRun = dryRun
+	})
+}
+
+// deleteState removes the persisted state of an unsubscribed service
+func deleteState(ctx context.Context, service core.CaronteService) {
+
+	if stateStore == nil {
+		return
+	}
+
+	err := stateStore.Delete(ctx, service.Id, service.Name)
+	if err != nil {
+		zap.S().Errorf("Fail deleting service %s state: %s", service.Name, err)
+	}
+}
+
+// snapshot serializes the persisted part of the state
+func snapshot(state *serviceState) string {
+
+	persisted := statestores.ScalingState{
+		LastAction:            state.lastAction,
+		LastActionAt:          state.lastActionAt,
+		CoolDownUntil:         state.coolDownUntil,
+		InstanceCoolDownUntil: state.instanceCoolDownUntil,
+		IdleSince:             state.idleSince,
+		Paused:                state.paused,
+		ManualReplicas:        state.manualReplicas,
+		ManualUntil:           state.manualUntil,
+	}
+	for _, recommendation := range state.recommendations {
+		persisted.Recommendations = append(persisted.Recommendations, statestores.Recommendation{At: recommendation.at, Replicas: recommendation.replicas})
+	}
+	for name, policyState := range state.policies {
+		if controller, ok := policyState.(pidState); ok {
+			if persisted.PID == nil {
+				persisted.PID = make(map[string]statestores.PIDState)
+			}
+			persisted.PID[name] = statestores.PIDState{Bias: controller.bias, Integral: controller.integral, LastError: controller.lastError, LastTick: controller.lastTick}
+		}
+	}
+
+	content, _ := json.Marshal(persisted)
+	return string(content)
+}
+
+// controlSnapshot serializes the last saved state with the control changes of a dry run
+// service, the bookkeeping of its pretended actions is kept only in memory
+func controlSnapshot(state *serviceState) string {
+
+	var persisted statestores.ScalingState
+	if state.persisted != "" {
+		json.Unmarshal([]byte(state.persisted), &persisted)
+	}
+	persisted.Paused = state.paused
+	persisted.ManualReplicas = state.manualReplicas
+	persisted.ManualUntil = state.manualUntil
+
+	content, _ := json.Marshal(persisted)
+	return string(content)
+}
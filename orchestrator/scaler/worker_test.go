@@ -0,0 +1,336 @@
+package scaler
+
+import (
+	"Caronte/core"
+	"Caronte/engine"
+	"Caronte/metricstores"
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// workerSwarm is a swarm engine whose services run the replicas they were last scaled to. It
+// counts the ticks of a service running at the same time and can hold the scale calls
+type workerSwarm struct {
+	engine.SwarmEngine
+
+	lock     sync.Mutex
+	replicas map[string]int
+	ticking  map[string]int
+	overlaps int
+	scaling  chan struct{}
+	release  chan struct{}
+	aborted  []error
+}
+
+func newWorkerSwarm() *workerSwarm {
+	return &workerSwarm{
+		replicas: make(map[string]int),
+		ticking:  make(map[string]int),
+	}
+}
+
+func (w *workerSwarm) TotalActiveTasks(ctx context.Context, serviceID string) (int, error) {
+
+	w.lock.Lock()
+	w.ticking[serviceID]++
+	if w.ticking[serviceID] > 1 {
+		w.overlaps++
+	}
+	replicas, contains := w.replicas[serviceID]
+	w.lock.Unlock()
+
+	//Widen the tick so overlapping workers are caught
+	time.Sleep(5 * time.Millisecond)
+
+	w.lock.Lock()
+	w.ticking[serviceID]--
+	w.lock.Unlock()
+
+	if !contains {
+		replicas = 2
+	}
+	return replicas, nil
+}
+
+func (w *workerSwarm) PendingTasks(ctx context.Context, serviceID string) (int, error) {
+	return 0, nil
+}
+
+func (w *workerSwarm) Scale(ctx context.Context, serviceID string, target int) (bool, error) {
+
+	if w.scaling != nil {
+		select {
+		case w.scaling <- struct{}{}:
+		default:
+		}
+		<-w.release
+		w.lock.Lock()
+		w.aborted = append(w.aborted, ctx.Err())
+		w.lock.Unlock()
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.replicas[serviceID] == target {
+		return false, nil
+	}
+	w.replicas[serviceID] = target
+	return true, nil
+}
+
+func (w *workerSwarm) overlapped() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.overlaps
+}
+
+// workerMetric returns a fixed value, or blocks until the query is cancelled
+type workerMetric struct {
+	value   float64
+	block   bool
+	lock    sync.Mutex
+	queries int
+	queried chan struct{}
+}
+
+func (m *workerMetric) Query(ctx context.Context, specs metricstores.MetricSpecs) (float64, error) {
+
+	m.lock.Lock()
+	m.queries++
+	m.lock.Unlock()
+
+	if !m.block {
+		return m.value, nil
+	}
+	select {
+	case m.queried <- struct{}{}:
+	default:
+	}
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func (m *workerMetric) count() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.queries
+}
+
+func workerService(name string, max int, provider metricstores.MetricProvider) core.CaronteService {
+	return core.CaronteService{
+		Id:               name,
+		Name:             name,
+		Min:              1,
+		Max:              max,
+		ServiceScheduler: 1,
+		Metrics: []core.ServiceMetric{{
+			Name:               core.DefaultMetric,
+			Step:               1,
+			ScaleUpThreshold:   80,
+			ScaleDownThreshold: 20,
+			MetricProvider:     provider,
+		}},
+	}
+}
+
+// initWorkers starts the scaler loop and returns the function stopping it
+func initWorkers(t *testing.T, s ServiceScale) (chan core.CaronteService, chan core.CaronteService, func()) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	services := make(chan core.CaronteService)
+	unsubscribe := make(chan core.CaronteService)
+	s.Init(ctx, services, unsubscribe)
+
+	return services, unsubscribe, func() {
+		cancel()
+		select {
+		case <-Stopped():
+		case <-time.After(5 * time.Second):
+			t.Fatal("the service workers did not stop")
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRelabelStopsTheOldWorkerFirst(t *testing.T) {
+
+	swarmEngine := newWorkerSwarm()
+	services, _, stop := initWorkers(t, ServiceScale{SwarmEngine: swarmEngine})
+	defer stop()
+
+	metric := &workerMetric{value: 50}
+	for max := 10; max < 30; max++ {
+		services <- workerService("relabelled", max, metric)
+	}
+
+	waitFor(t, "the last configuration to tick", func() bool {
+		records, _ := Decisions("relabelled")
+		return len(records) > 0 && records[len(records)-1].Max == 29
+	})
+	if overlaps := swarmEngine.overlapped(); overlaps != 0 {
+		t.Errorf("%d ticks of an old configuration overlapped the new one", overlaps)
+	}
+}
+
+func TestRelabelLetsTheScaleInFlightFinish(t *testing.T) {
+
+	swarmEngine := newWorkerSwarm()
+	swarmEngine.scaling = make(chan struct{}, 1)
+	swarmEngine.release = make(chan struct{})
+	services, _, stop := initWorkers(t, ServiceScale{SwarmEngine: swarmEngine})
+	defer stop()
+
+	metric := &workerMetric{value: 90}
+	services <- workerService("inflight", 10, metric)
+	<-swarmEngine.scaling
+
+	relabelled := make(chan struct{})
+	go func() {
+		services <- workerService("inflight", 20, metric)
+		close(relabelled)
+	}()
+
+	//The old worker is cancelled while its scale is held
+	time.Sleep(50 * time.Millisecond)
+	close(swarmEngine.release)
+	<-relabelled
+
+	waitFor(t, "the scale to finish", func() bool {
+		swarmEngine.lock.Lock()
+		defer swarmEngine.lock.Unlock()
+		return len(swarmEngine.aborted) > 0
+	})
+	swarmEngine.lock.Lock()
+	err := swarmEngine.aborted[0]
+	swarmEngine.lock.Unlock()
+	if err != nil {
+		t.Errorf("the relabel aborted the scale in flight: %s", err)
+	}
+
+	waitFor(t, "the scale decision", func() bool {
+		records, _ := Decisions("inflight")
+		for _, record := range records {
+			if record.Action == ActionScaleUp && record.Max == 10 {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestUnsubscribeCancelsTheWorker(t *testing.T) {
+
+	swarmEngine := newWorkerSwarm()
+	services, unsubscribe, stop := initWorkers(t, ServiceScale{SwarmEngine: swarmEngine})
+	defer stop()
+
+	metric := &workerMetric{block: true, queried: make(chan struct{}, 1)}
+	service := workerService("unsubscribed", 10, metric)
+	services <- service
+	<-metric.queried
+
+	done := make(chan struct{})
+	go func() {
+		unsubscribe <- service
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the unsubscribe did not cancel the blocked query")
+	}
+
+	queries := metric.count()
+	time.Sleep(1500 * time.Millisecond)
+	if metric.count() != queries {
+		t.Error("the worker kept ticking after the unsubscribe")
+	}
+	if _, contains := Decisions("unsubscribed"); contains {
+		t.Error("the decisions of the unsubscribed service were kept")
+	}
+}
+
+func TestInvalidServiceKeepsItsState(t *testing.T) {
+
+	store := useMemoryStore(t)
+	swarmEngine := newWorkerSwarm()
+	services, _, stop := initWorkers(t, ServiceScale{SwarmEngine: swarmEngine})
+	defer stop()
+	defer forgetState("invalid")
+
+	metric := &workerMetric{value: 90}
+	service := workerService("invalid", 10, metric)
+	services <- service
+	waitFor(t, "the scale up", func() bool {
+		return Status(service.Name).LastAction == ActionScaleUp
+	})
+
+	invalid := service
+	invalid.Errors = []error{errors.New("max is not a number")}
+	services <- invalid
+
+	queries := metric.count()
+	time.Sleep(1500 * time.Millisecond)
+	if metric.count() != queries {
+		t.Error("the invalid service kept being scaled")
+	}
+	if Status(service.Name).LastAction != ActionScaleUp {
+		t.Error("the scaling state of the invalid service was dropped")
+	}
+	if _, found, _ := store.Load(context.Background(), service.Id, service.Name); !found {
+		t.Error("the persisted state of the invalid service was deleted")
+	}
+}
+
+func TestControlWhileTicking(t *testing.T) {
+
+	useMemoryStore(t)
+	swarmEngine := newWorkerSwarm()
+	s := ServiceScale{SwarmEngine: swarmEngine}
+	services, _, stop := initWorkers(t, s)
+	defer stop()
+
+	metric := &workerMetric{value: 90}
+	service := workerService("controlled", 10, metric)
+	services <- service
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	run := func(call func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				call()
+			}
+		}()
+	}
+	run(func() { s.Scale(context.Background(), service) })
+	run(func() { Pause(ctx, service); Resume(ctx, service) })
+	run(func() { ForceReplicas(ctx, service, 3, time.Minute); ClearReplicas(ctx, service) })
+	run(func() { Status(service.Name) })
+	run(func() { Decisions(service.Name) })
+	run(func() { LastDecisions() })
+	wg.Wait()
+
+	if records, _ := Decisions(service.Name); len(records) == 0 {
+		t.Error("no decision recorded while the service was controlled")
+	}
+}
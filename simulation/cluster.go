@@ -0,0 +1,216 @@
+package simulation
+
+import (
+	"Caronte/instances"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+)
+
+var errNotSimulated = errors.New("not available in the simulation")
+
+// task is a replica of the simulated service. A task is pending until a node has room for it
+// and running once the startup delay has passed since it was placed
+type task struct {
+	placed  bool
+	readyAt time.Time
+}
+
+// node is an instance of the simulated cluster, it takes tasks once it has booted
+type node struct {
+	readyAt time.Time
+}
+
+// cluster models the replicas of the service and, with an instance provider, the instances
+// they run on
+type cluster struct {
+	now                time.Time
+	tasks              []task
+	nodes              []node
+	withInstances      bool
+	maxReplicasPerNode int
+	minInstances       int
+	maxInstances       int
+	startupDelay       time.Duration
+	bootTime           time.Duration
+}
+
+// advance moves the cluster to the given time, placing the pending tasks on the nodes with room
+// and moving back to pending the tasks of the removed nodes
+func (c *cluster) advance(now time.Time) {
+	c.now = now
+
+	capacity := c.capacity()
+	placed := 0
+	for i := range c.tasks {
+		if !c.tasks[i].placed {
+			continue
+		}
+		if placed >= capacity {
+			c.tasks[i].placed = false
+			continue
+		}
+		placed++
+	}
+	for i := range c.tasks {
+		if placed >= capacity {
+			break
+		}
+		if !c.tasks[i].placed {
+			c.tasks[i].placed = true
+			c.tasks[i].readyAt = now.Add(c.startupDelay)
+			placed++
+		}
+	}
+}
+
+// capacity is the number of tasks the booted nodes can run
+func (c *cluster) capacity() int {
+	if !c.withInstances {
+		return len(c.tasks)
+	}
+	return c.readyInstances() * c.maxReplicasPerNode
+}
+
+func (c *cluster) running() int {
+	running := 0
+	for _, task := range c.tasks {
+		if task.placed && !task.readyAt.After(c.now) {
+			running++
+		}
+	}
+	return running
+}
+
+func (c *cluster) pending() int {
+	pending := 0
+	for _, task := range c.tasks {
+		if !task.placed {
+			pending++
+		}
+	}
+	return pending
+}
+
+func (c *cluster) readyInstances() int {
+	ready := 0
+	for _, node := range c.nodes {
+		if !node.readyAt.After(c.now) {
+			ready++
+		}
+	}
+	return ready
+}
+
+func (c *cluster) booting() bool {
+	return c.readyInstances() < len(c.nodes)
+}
+
+// scaleTasks adds pending tasks or removes tasks, the pending ones first
+func (c *cluster) scaleTasks(target int) {
+	for len(c.tasks) < target {
+		c.tasks = append(c.tasks, task{})
+	}
+	for i := len(c.tasks) - 1; i >= 0 && len(c.tasks) > target; i-- {
+		if !c.tasks[i].placed {
+			c.tasks = append(c.tasks[:i], c.tasks[i+1:]...)
+		}
+	}
+	if len(c.tasks) > target {
+		c.tasks = c.tasks[:target]
+	}
+	c.advance(c.now)
+}
+
+// fakeSwarm is the SwarmEngine of the simulated service
+type fakeSwarm struct {
+	cluster *cluster
+}
+
+func (s fakeSwarm) NodeID(ctx context.Context) (string, error) {
+	return "", errNotSimulated
+}
+
+func (s fakeSwarm) IsLeader(ctx context.Context, nodeID string) (bool, error) {
+	return true, nil
+}
+
+func (s fakeSwarm) ServiceCurrentReplicas(ctx context.Context, serviceID string) (int, error) {
+	return len(s.cluster.tasks), nil
+}
+
+func (s fakeSwarm) GetService(ctx context.Context, serviceID string) (swarm.Service, error) {
+	return swarm.Service{}, errNotSimulated
+}
+
+func (s fakeSwarm) GetServices(ctx context.Context, args filters.Args) ([]swarm.Service, error) {
+	return nil, errNotSimulated
+}
+
+func (s fakeSwarm) Scale(ctx context.Context, serviceID string, target int) (bool, error) {
+	if len(s.cluster.tasks) == target {
+		return false, nil
+	}
+	s.cluster.scaleTasks(target)
+	return true, nil
+}
+
+func (s fakeSwarm) UpdateLabel(ctx context.Context, serviceID string, key string, value string) error {
+	return errNotSimulated
+}
+
+func (s fakeSwarm) OnGoingTasks(ctx context.Context, serviceID string) (int, error) {
+	return len(s.cluster.tasks) - s.cluster.running(), nil
+}
+
+func (s fakeSwarm) PendingTasks(ctx context.Context, serviceID string) (int, error) {
+	return s.cluster.pending(), nil
+}
+
+func (s fakeSwarm) RunningTasks(ctx context.Context, serviceID string) (int, error) {
+	return s.cluster.running(), nil
+}
+
+func (s fakeSwarm) TotalActiveTasks(ctx context.Context, serviceID string) (int, error) {
+	return len(s.cluster.tasks), nil
+}
+
+func (s fakeSwarm) Events(ctx context.Context, args filters.Args) (<-chan events.Message, <-chan error) {
+	errs := make(chan error, 1)
+	errs <- errNotSimulated
+	return nil, errs
+}
+
+// fakeProvider is the InstanceManagerProvider of the simulated cluster. As an autoscaling group it
+// adds or removes one instance at a time and refuses to scale while an instance is booting
+type fakeProvider struct {
+	cluster *cluster
+}
+
+func (p fakeProvider) Scale(ctx context.Context, scaleSpecs instances.ScaleSpecs, direction int) bool {
+	c := p.cluster
+	if c.booting() {
+		return false
+	}
+	if direction > 0 {
+		if len(c.nodes) >= c.maxInstances {
+			return false
+		}
+		c.nodes = append(c.nodes, node{readyAt: c.now.Add(c.bootTime)})
+		return true
+	}
+	if len(c.nodes) <= c.minInstances {
+		return false
+	}
+	c.nodes = c.nodes[:len(c.nodes)-1]
+	c.advance(c.now)
+	return true
+}
+
+func (p fakeProvider) RunningInstances(ctx context.Context, scaleSpecs instances.ScaleSpecs) int {
+	return len(p.cluster.nodes)
+}
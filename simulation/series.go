@@ -0,0 +1,148 @@
+package simulation
+
+import (
+	"Caronte/core"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sample is the value of every recorded metric at a given time
+type Sample struct {
+	Time   time.Time
+	Values map[string]float64
+}
+
+// jsonSample is a sample of a JSON series. The time is RFC3339 or unix seconds, value is the
+// value of the default metric and values the value of each named metric
+type jsonSample struct {
+	Time   json.RawMessage    `json:"time"`
+	Value  *float64           `json:"value"`
+	Values map[string]float64 `json:"values"`
+}
+
+// LoadSeries reads a recorded metric series sorted by time. A .json file holds an array of
+// samples, any other file is a CSV whose header names the time column first and then one column
+// per metric, the value column being the default metric
+func LoadSeries(path string) ([]Sample, error) {
+
+	var samples []Sample
+	var err error
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		samples, err = loadJSONSeries(path)
+	} else {
+		samples, err = loadCSVSeries(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, errors.New("the series has no samples")
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+	return samples, nil
+}
+
+func loadJSONSeries(path string) ([]Sample, error) {
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []jsonSample
+	if err := json.Unmarshal(content, &records); err != nil {
+		return nil, err
+	}
+
+	samples := make([]Sample, 0, len(records))
+	for i, record := range records {
+		var raw interface{}
+		if err := json.Unmarshal(record.Time, &raw); err != nil {
+			return nil, fmt.Errorf("sample %d: %s", i, err)
+		}
+		at, err := parseTime(fmt.Sprint(raw))
+		if err != nil {
+			return nil, fmt.Errorf("sample %d: %s", i, err)
+		}
+
+		values := make(map[string]float64)
+		for name, value := range record.Values {
+			values[name] = value
+		}
+		if record.Value != nil {
+			values[core.DefaultMetric] = *record.Value
+		}
+		samples = append(samples, Sample{Time: at, Values: values})
+	}
+	return samples, nil
+}
+
+func loadCSVSeries(path string) ([]Sample, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 || len(rows[0]) < 2 {
+		return nil, errors.New("the csv series needs a header with a time and a metric column")
+	}
+
+	names := make([]string, len(rows[0]))
+	for i, name := range rows[0] {
+		names[i] = strings.TrimSpace(name)
+		if names[i] == "value" {
+			names[i] = core.DefaultMetric
+		}
+	}
+
+	samples := make([]Sample, 0, len(rows)-1)
+	for line, row := range rows[1:] {
+		at, err := parseTime(strings.TrimSpace(row[0]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", line+2, err)
+		}
+		values := make(map[string]float64)
+		for i := 1; i < len(row) && i < len(names); i++ {
+			if strings.TrimSpace(row[i]) == "" {
+				continue
+			}
+			value, err := strconv.ParseFloat(strings.TrimSpace(row[i]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", line+2, err)
+			}
+			values[names[i]] = value
+		}
+		samples = append(samples, Sample{Time: at, Values: values})
+	}
+	return samples, nil
+}
+
+// parseTime reads RFC3339 times and unix seconds
+func parseTime(value string) (time.Time, error) {
+	if at, err := time.Parse(time.RFC3339, value); err == nil {
+		return at, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s", value)
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))).UTC(), nil
+}
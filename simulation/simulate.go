@@ -0,0 +1,405 @@
+package simulation
+
+import (
+	"Caronte/core"
+	"Caronte/metricstores"
+	"Caronte/orchestrator/scaler"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+)
+
+// Options configures a simulation run
+type Options struct {
+	Service      string
+	Labels       map[string]string
+	Series       []Sample
+	Replicas     int
+	Instances    int
+	MinInstances int
+	MaxInstances int
+	StartupDelay time.Duration
+	BootTime     time.Duration
+	Interval     time.Duration
+	PerReplica   bool
+}
+
+// Point is the state of the simulated service after a scaling tick
+type Point struct {
+	Time           time.Time          `json:"time"`
+	Metrics        map[string]float64 `json:"metrics"`
+	Replicas       int                `json:"replicas"`
+	Running        int                `json:"running"`
+	Pending        int                `json:"pending"`
+	Instances      int                `json:"instances"`
+	ReadyInstances int                `json:"readyInstances"`
+	Action         string             `json:"action"`
+	InstanceAction string             `json:"instanceAction,omitempty"`
+	Reason         string             `json:"reason"`
+}
+
+// Summary aggregates the timeline of a simulation
+type Summary struct {
+	Start              time.Time          `json:"start"`
+	End                time.Time          `json:"end"`
+	Ticks              int                `json:"ticks"`
+	ScaleUps           int                `json:"scaleUps"`
+	ScaleDowns         int                `json:"scaleDowns"`
+	InstanceScaleUps   int                `json:"instanceScaleUps"`
+	InstanceScaleDowns int                `json:"instanceScaleDowns"`
+	MinReplicas        int                `json:"minReplicas"`
+	MaxReplicas        int                `json:"maxReplicas"`
+	AverageReplicas    float64            `json:"averageReplicas"`
+	PendingSeconds     float64            `json:"pendingSeconds"`
+	OverThreshold      map[string]float64 `json:"overThresholdSeconds"`
+}
+
+// Result is the timeline and the summary of a simulation
+type Result struct {
+	Timeline []Point `json:"timeline"`
+	Summary  Summary `json:"summary"`
+}
+
+// seriesMetric replays the recorded values of a metric, divided by the running replicas when
+// the series holds the load of the whole service
+type seriesMetric struct {
+	simulation *simulation
+	name       string
+}
+
+func (m seriesMetric) Query(ctx context.Context, specs metricstores.MetricSpecs) (float64, error) {
+	value, ok := m.simulation.value(m.name)
+	if !ok {
+		return 0, fmt.Errorf("metric %s has no recorded value", m.name)
+	}
+	return value, nil
+}
+
+type simulation struct {
+	options Options
+	cluster *cluster
+	sample  int
+}
+
+// value returns the last recorded value of the metric at the cluster time
+func (s *simulation) value(name string) (float64, bool) {
+	for s.sample+1 < len(s.options.Series) && !s.options.Series[s.sample+1].Time.After(s.cluster.now) {
+		s.sample++
+	}
+	value, ok := s.options.Series[s.sample].Values[name]
+	if !ok {
+		return 0, false
+	}
+	if s.options.PerReplica {
+		running := s.cluster.running()
+		if running < 1 {
+			running = 1
+		}
+		value = value / float64(running)
+	}
+	return value, true
+}
+
+// Run replays the series through the scaler with a simulated swarm and instance provider
+func Run(options Options) (Result, error) {
+
+	if len(options.Series) == 0 {
+		return Result{}, errors.New("the series has no samples")
+	}
+
+	service := core.NewCaronteService(options.Service, options.Service, swarm.Annotations{Name: options.Service, Labels: options.Labels})
+	if len(service.Errors) > 0 {
+		var messages []string
+		for _, err := range service.Errors {
+			messages = append(messages, err.Error())
+		}
+		return Result{}, fmt.Errorf("invalid service labels:\n  %s", strings.Join(messages, "\n  "))
+	}
+
+	s := &simulation{
+		options: options,
+		cluster: &cluster{
+			now:                options.Series[0].Time,
+			withInstances:      service.InstanceSpecs.Provider != "",
+			maxReplicasPerNode: service.MaxReplicasPerNode,
+			minInstances:       options.MinInstances,
+			maxInstances:       options.MaxInstances,
+			startupDelay:       options.StartupDelay,
+			bootTime:           options.BootTime,
+		},
+	}
+	if s.cluster.withInstances && s.cluster.maxReplicasPerNode <= 0 {
+		return Result{}, errors.New("caronte.scale.maxReplicasPerNode is required with an instance provider")
+	}
+
+	for i := range service.Metrics {
+		service.Metrics[i].MetricProvider = seriesMetric{simulation: s, name: service.Metrics[i].Name}
+	}
+	if service.Activation.Name != "" {
+		service.Activation.MetricProvider = seriesMetric{simulation: s, name: service.Activation.Name}
+	}
+	if s.cluster.withInstances {
+		service.InstanceProvider = fakeProvider{cluster: s.cluster}
+		for i := 0; i < options.Instances; i++ {
+			s.cluster.nodes = append(s.cluster.nodes, node{readyAt: s.cluster.now})
+		}
+	}
+	for i := 0; i < options.Replicas; i++ {
+		s.cluster.tasks = append(s.cluster.tasks, task{placed: true, readyAt: s.cluster.now})
+	}
+	s.cluster.advance(s.cluster.now)
+
+	interval := options.Interval
+	if interval <= 0 {
+		interval = time.Duration(service.ServiceScheduler) * time.Second
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+
+	scaler.SetClock(func() time.Time { return s.cluster.now })
+	defer scaler.SetClock(nil)
+
+	serviceScale := scaler.ServiceScale{SwarmEngine: fakeSwarm{cluster: s.cluster}}
+	ctx := context.Background()
+
+	var result Result
+	summary := Summary{
+		Start:         options.Series[0].Time,
+		End:           options.Series[len(options.Series)-1].Time,
+		OverThreshold: make(map[string]float64),
+		MinReplicas:   len(s.cluster.tasks),
+		MaxReplicas:   len(s.cluster.tasks),
+	}
+	replicaTicks := 0
+	for _, metric := range service.Metrics {
+		summary.OverThreshold[metric.Name] = 0
+	}
+
+	for now := summary.Start; !now.After(summary.End); now = now.Add(interval) {
+		s.cluster.advance(now)
+
+		point := Point{Time: now, Metrics: make(map[string]float64)}
+		for _, metric := range service.Metrics {
+			value, ok := s.value(metric.Name)
+			if !ok {
+				continue
+			}
+			point.Metrics[metric.Name] = value
+			threshold := metric.ScaleUpThreshold
+			if service.Policy != core.ScalePolicyThreshold {
+				threshold = metric.Target
+			}
+			if threshold > 0 && value > threshold {
+				summary.OverThreshold[metric.Name] += interval.Seconds()
+			}
+		}
+
+		serviceScale.Scale(ctx, service)
+
+		point.Action = scaler.ActionNone
+		if records, ok := scaler.Decisions(service.Name); ok && len(records) > 0 {
+			record := records[len(records)-1]
+			point.Action = record.Action
+			point.InstanceAction = record.InstanceAction
+			point.Reason = record.Reason
+		}
+		point.Replicas = len(s.cluster.tasks)
+		point.Running = s.cluster.running()
+		point.Pending = s.cluster.pending()
+		point.Instances = len(s.cluster.nodes)
+		point.ReadyInstances = s.cluster.readyInstances()
+		result.Timeline = append(result.Timeline, point)
+
+		summary.Ticks++
+		switch point.Action {
+		case scaler.ActionScaleUp:
+			summary.ScaleUps++
+		case scaler.ActionScaleDown:
+			summary.ScaleDowns++
+		}
+		switch point.InstanceAction {
+		case scaler.ActionScaleUp:
+			summary.InstanceScaleUps++
+		case scaler.ActionScaleDown:
+			summary.InstanceScaleDowns++
+		}
+		if point.Replicas < summary.MinReplicas {
+			summary.MinReplicas = point.Replicas
+		}
+		if point.Replicas > summary.MaxReplicas {
+			summary.MaxReplicas = point.Replicas
+		}
+		if point.Pending > 0 {
+			summary.PendingSeconds += interval.Seconds()
+		}
+		replicaTicks += point.Replicas
+	}
+	summary.AverageReplicas = float64(replicaTicks) / float64(summary.Ticks)
+	result.Summary = summary
+
+	return result, nil
+}
+
+// labelFlags collects the repeated -label key=value flags
+type labelFlags map[string]string
+
+func (l labelFlags) String() string {
+	return fmt.Sprint(map[string]string(l))
+}
+
+func (l labelFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("label %s is not key=value", value)
+	}
+	l[parts[0]] = parts[1]
+	return nil
+}
+
+// Command runs the simulate subcommand with its arguments and returns the exit code
+func Command(args []string, out io.Writer) int {
+
+	flags := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	labelsFile := flags.String("labels", "", "JSON file with the service labels")
+	labels := labelFlags{}
+	flags.Var(labels, "label", "Service label key=value, overrides the labels file. Repeatable")
+	seriesFile := flags.String("series", "", "Recorded metric series, a .json file or a csv file")
+	service := flags.String("service", "simulated", "Name of the simulated service")
+	replicas := flags.Int("replicas", -1, "Replicas at the start of the simulation. Default value the service min")
+	instances := flags.Int("instances", -1, "Instances at the start of the simulation. Default value the instances needed by the replicas")
+	minInstances := flags.Int("instances.min", 1, "Minimum instances of the simulated autoscaling group")
+	maxInstances := flags.Int("instances.max", 100, "Maximum instances of the simulated autoscaling group")
+	startupDelay := flags.Int("startup.delay", 10, "Seconds a task takes to run once placed on a node")
+	bootTime := flags.Int("boot.time", 120, "Seconds an instance takes to boot")
+	interval := flags.Int("interval", 0, "Seconds between scaling ticks. Default value the service scale time or 10")
+	perReplica := flags.Bool("per-replica", false, "The series holds the load of the whole service, the scaler sees it divided by the running replicas")
+	format := flags.String("format", "text", "Output format {text or json}")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *seriesFile == "" {
+		fmt.Fprintln(os.Stderr, "simulate: -series is required")
+		flags.Usage()
+		return 2
+	}
+
+	serviceLabels := make(map[string]string)
+	if *labelsFile != "" {
+		content, err := ioutil.ReadFile(*labelsFile)
+		if err == nil {
+			err = json.Unmarshal(content, &serviceLabels)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "simulate: labels %s: %s\n", *labelsFile, err)
+			return 1
+		}
+	}
+	for key, value := range labels {
+		serviceLabels[key] = value
+	}
+
+	series, err := LoadSeries(*seriesFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "simulate: series %s: %s\n", *seriesFile, err)
+		return 1
+	}
+
+	options := Options{
+		Service:      *service,
+		Labels:       serviceLabels,
+		Series:       series,
+		Replicas:     *replicas,
+		Instances:    *instances,
+		MinInstances: *minInstances,
+		MaxInstances: *maxInstances,
+		StartupDelay: time.Duration(*startupDelay) * time.Second,
+		BootTime:     time.Duration(*bootTime) * time.Second,
+		Interval:     time.Duration(*interval) * time.Second,
+		PerReplica:   *perReplica,
+	}
+	parsed := core.NewCaronteService(*service, *service, swarm.Annotations{Labels: serviceLabels})
+	if options.Replicas < 0 {
+		options.Replicas = parsed.Min
+	}
+	if options.Instances < 0 {
+		options.Instances = 0
+		if parsed.MaxReplicasPerNode > 0 {
+			options.Instances = (options.Replicas + parsed.MaxReplicasPerNode - 1) / parsed.MaxReplicasPerNode
+		}
+	}
+
+	result, err := Run(options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "simulate: %s\n", err)
+		return 1
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+		return 0
+	}
+	writeText(out, result)
+	return 0
+}
+
+// writeText prints the timeline as a table followed by the summary
+func writeText(out io.Writer, result Result) {
+
+	var names []string
+	for name := range result.Summary.OverThreshold {
+		names = append(names, name)
+	}
+	if len(result.Timeline) > 0 {
+		for name := range result.Timeline[0].Metrics {
+			if _, contains := result.Summary.OverThreshold[name]; !contains {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "TIME")
+	for _, name := range names {
+		fmt.Fprintf(w, "\t%s", strings.ToUpper(name))
+	}
+	fmt.Fprintln(w, "\tREPLICAS\tRUNNING\tPENDING\tINSTANCES\tACTION\tINSTANCE ACTION\tREASON")
+	for _, point := range result.Timeline {
+		fmt.Fprint(w, point.Time.Format(time.RFC3339))
+		for _, name := range names {
+			if value, ok := point.Metrics[name]; ok {
+				fmt.Fprintf(w, "\t%g", value)
+			} else {
+				fmt.Fprint(w, "\t-")
+			}
+		}
+		fmt.Fprintf(w, "\t%d\t%d\t%d\t%d/%d\t%s\t%s\t%s\n", point.Replicas, point.Running, point.Pending,
+			point.ReadyInstances, point.Instances, point.Action, point.InstanceAction, point.Reason)
+	}
+	w.Flush()
+
+	summary := result.Summary
+	fmt.Fprintf(out, "\nSimulated %s from %s to %s in %d ticks\n", summary.End.Sub(summary.Start), summary.Start.Format(time.RFC3339), summary.End.Format(time.RFC3339), summary.Ticks)
+	fmt.Fprintf(out, "Service scale ups %d, scale downs %d\n", summary.ScaleUps, summary.ScaleDowns)
+	fmt.Fprintf(out, "Instance scale ups %d, scale downs %d\n", summary.InstanceScaleUps, summary.InstanceScaleDowns)
+	fmt.Fprintf(out, "Replicas min %d, max %d, average %.2f\n", summary.MinReplicas, summary.MaxReplicas, summary.AverageReplicas)
+	fmt.Fprintf(out, "Time with pending tasks %s\n", time.Duration(summary.PendingSeconds)*time.Second)
+	for _, name := range names {
+		fmt.Fprintf(out, "Metric %s over threshold %s\n", name, time.Duration(result.Summary.OverThreshold[name])*time.Second)
+	}
+}
@@ -0,0 +1,66 @@
+package statestores
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore keeps the state of every service in a JSON file named after the service
+type FileStore struct {
+	Dir string
+}
+
+func NewFileStore(dir string) (FileStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	return FileStore{Dir: dir}, err
+}
+
+func (f FileStore) Load(ctx context.Context, serviceID string, name string) (ScalingState, bool, error) {
+
+	var state ScalingState
+
+	content, err := ioutil.ReadFile(f.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, false, nil
+		}
+		return state, false, err
+	}
+
+	err = json.Unmarshal(content, &state)
+	if err != nil {
+		return state, false, err
+	}
+
+	return state, true, nil
+}
+
+func (f FileStore) Save(ctx context.Context, serviceID string, name string, state ScalingState) error {
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp := f.path(name) + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(name))
+}
+
+func (f FileStore) Delete(ctx context.Context, serviceID string, name string) error {
+	err := os.Remove(f.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f FileStore) path(name string) string {
+	return filepath.Join(f.Dir, filepath.Base(name)+".json")
+}
@@ -0,0 +1,105 @@
+package statestores
+
+import (
+	"Caronte/engine"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// StateLabel is the service label where the labels store writes the scaling state
+const StateLabel = "caronte.state"
+
+// LabelsWriteInterval is the minimum time between two label writes of a state that only
+// changed in the stabilization history or the pid memory, which change on every tick
+var LabelsWriteInterval = 5 * time.Minute
+
+// labelWrite is the last state written into the label of a service, without the fields
+// changing on every tick
+type labelWrite struct {
+	at      time.Time
+	durable string
+}
+
+var labelWrites = make(map[string]labelWrite)
+var labelWritesLock sync.Mutex
+
+// LabelsStore keeps the state of every service in its own caronte.state label, so the state
+// follows the service whatever node Caronte runs on
+type LabelsStore struct {
+	SwarmEngine engine.SwarmEngine
+}
+
+func NewLabelsStore() (LabelsStore, error) {
+	swarmEngine, err := engine.NewSwarm()
+	return LabelsStore{SwarmEngine: swarmEngine}, err
+}
+
+func (l LabelsStore) Load(ctx context.Context, serviceID string, name string) (ScalingState, bool, error) {
+
+	var state ScalingState
+
+	service, err := l.SwarmEngine.GetService(ctx, serviceID)
+	if err != nil {
+		return state, false, err
+	}
+
+	value := service.Spec.Labels[StateLabel]
+	if value == "" {
+		return state, false, nil
+	}
+
+	err = json.Unmarshal([]byte(value), &state)
+	if err != nil {
+		return state, false, err
+	}
+
+	return state, true, nil
+}
+
+// Save writes the state into the service label. Every write is a service update, so a state
+// only differing in the stabilization history or the pid memory from the last one written is
+// written at most every LabelsWriteInterval
+func (l LabelsStore) Save(ctx context.Context, serviceID string, name string, state ScalingState) error {
+
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	durable := state
+	durable.Recommendations = nil
+	durable.PID = nil
+	durableContent, err := json.Marshal(durable)
+	if err != nil {
+		return err
+	}
+
+	labelWritesLock.Lock()
+	last, written := labelWrites[serviceID]
+	labelWritesLock.Unlock()
+	if written && last.durable == string(durableContent) && time.Since(last.at) < LabelsWriteInterval {
+		return nil
+	}
+
+	err = l.SwarmEngine.UpdateLabel(ctx, serviceID, StateLabel, string(content))
+	if err != nil {
+		return err
+	}
+
+	labelWritesLock.Lock()
+	labelWrites[serviceID] = labelWrite{at: time.Now(), durable: string(durableContent)}
+	labelWritesLock.Unlock()
+
+	return nil
+}
+
+func (l LabelsStore) Delete(ctx context.Context, serviceID string, name string) error {
+	//The label leaves with the service
+	labelWritesLock.Lock()
+	defer labelWritesLock.Unlock()
+
+	delete(labelWrites, serviceID)
+	return nil
+}
@@ -0,0 +1,61 @@
+package statestores
+
+import (
+	"Caronte/engine"
+	"context"
+	"testing"
+	"time"
+)
+
+// labelsSwarm counts the label writes
+type labelsSwarm struct {
+	engine.SwarmEngine
+	writes int
+}
+
+func (l *labelsSwarm) UpdateLabel(ctx context.Context, serviceID string, key string, value string) error {
+	l.writes++
+	return nil
+}
+
+func TestLabelsStoreThrottlesTickFields(t *testing.T) {
+
+	defer func(interval time.Duration) { LabelsWriteInterval = interval }(LabelsWriteInterval)
+	LabelsWriteInterval = time.Hour
+
+	swarmEngine := &labelsSwarm{}
+	store := LabelsStore{SwarmEngine: swarmEngine}
+	ctx := context.Background()
+	now := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+
+	state := ScalingState{LastAction: "scaleUp", LastActionAt: now}
+	for i := 0; i < 10; i++ {
+		state.Recommendations = append(state.Recommendations, Recommendation{At: now.Add(time.Duration(i) * time.Second), Replicas: 3})
+		state.PID = map[string]PIDState{"default": {Integral: float64(i), LastTick: now.Add(time.Duration(i) * time.Second)}}
+		if err := store.Save(ctx, "throttled", "api", state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if swarmEngine.writes != 1 {
+		t.Errorf("ticks changing the history wrote the label %d times, want 1", swarmEngine.writes)
+	}
+
+	state.CoolDownUntil = now.Add(time.Minute)
+	if err := store.Save(ctx, "throttled", "api", state); err != nil {
+		t.Fatal(err)
+	}
+	if swarmEngine.writes != 2 {
+		t.Errorf("a new cool down wrote the label %d times in total, want 2", swarmEngine.writes)
+	}
+
+	LabelsWriteInterval = 0
+	state.Recommendations = nil
+	if err := store.Save(ctx, "throttled", "api", state); err != nil {
+		t.Fatal(err)
+	}
+	if swarmEngine.writes != 3 {
+		t.Errorf("the history was not written once the interval passed, %d writes", swarmEngine.writes)
+	}
+
+	store.Delete(ctx, "throttled", "api")
+}
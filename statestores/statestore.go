@@ -0,0 +1,61 @@
+package statestores
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ScalingState is the scaling bookkeeping of a service persisted across Caronte restarts
+type ScalingState struct {
+	LastAction            string              `json:"lastAction,omitempty"`
+	LastActionAt          time.Time           `json:"lastActionAt,omitempty"`
+	CoolDownUntil         time.Time           `json:"coolDownUntil,omitempty"`
+	InstanceCoolDownUntil time.Time           `json:"instanceCoolDownUntil,omitempty"`
+	IdleSince             time.Time           `json:"idleSince,omitempty"`
+	Recommendations       []Recommendation    `json:"recommendations,omitempty"`
+	PID                   map[string]PIDState `json:"pid,omitempty"`
+	Paused                bool                `json:"paused,omitempty"`
+	ManualReplicas        int                 `json:"manualReplicas,omitempty"`
+	ManualUntil           time.Time           `json:"manualUntil,omitempty"`
+}
+
+// Recommendation is a desired replicas recommendation of the stabilization window
+type Recommendation struct {
+	At       time.Time `json:"at"`
+	Replicas int       `json:"replicas"`
+}
+
+// PIDState is the controller memory of a metric scaled with the pid policy
+type PIDState struct {
+	Bias      float64   `json:"bias,omitempty"`
+	Integral  float64   `json:"integral"`
+	LastError float64   `json:"lastError"`
+	LastTick  time.Time `json:"lastTick"`
+}
+
+const (
+	File   = "file"
+	Labels = "labels"
+)
+
+// StateStore persists the scaling state of the services
+type StateStore interface {
+	Load(ctx context.Context, serviceID string, name string) (ScalingState, bool, error)
+	Save(ctx context.Context, serviceID string, name string, state ScalingState) error
+	Delete(ctx context.Context, serviceID string, name string) error
+}
+
+// NewStateStore returns the state store of the given kind. The file store keeps a JSON file
+// per service in dir, the labels store writes the state into the service own labels
+func NewStateStore(kind string, dir string) (StateStore, error) {
+
+	switch kind {
+	case File:
+		return NewFileStore(dir)
+	case Labels:
+		return NewLabelsStore()
+	}
+
+	return nil, errors.New("state store not supported " + kind)
+}